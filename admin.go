@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const defaultTopAlbums = 10
+
+// AdminServer serves the admin API and dashboard. Every endpoint but
+// /metrics requires the bearer token or a client certificate signed by the
+// configured CA.
+type AdminServer struct {
+	albumsKeys *AlbumsKeys
+	reloader   *ConfigReloader
+	stats      *Stats
+	health     *HealthChecker
+	token      string
+}
+
+func NewAdminServer(albumsKeys *AlbumsKeys, reloader *ConfigReloader, stats *Stats, health *HealthChecker, token string) *AdminServer {
+	return &AdminServer{
+		albumsKeys: albumsKeys,
+		reloader:   reloader,
+		stats:      stats,
+		health:     health,
+		token:      token,
+	}
+}
+
+// AdminAlbum is one entry of the album to API key map.
+type AdminAlbum struct {
+	AlbumID string `json:"albumId"`
+	Key     string `json:"key"` // fingerprint of the owning API key
+	AlbumMeta
+}
+
+// AdminKey is the sync and health state of one API key.
+type AdminKey struct {
+	Key string `json:"key"` // fingerprint
+	KeySyncStatus
+	Health   *KeyHealth `json:"health,omitempty"` // last key check, if any
+	AlbumIDs []string   `json:"albumIds"`
+}
+
+type AdminAlbumsResponse struct {
+	LastSync time.Time    `json:"lastSync"`
+	Albums   []AdminAlbum `json:"albums"`
+	Keys     []AdminKey   `json:"keys"`
+}
+
+// NewAdminRouter creates the router served on the admin listener. It is kept
+// separate from the public router so it can be bound to a private address.
+func NewAdminRouter(a *AdminServer) *mux.Router {
+	r := mux.NewRouter()
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+
+	auth := r.NewRoute().Subrouter()
+	auth.HandleFunc("/", a.DashboardHandler).Methods("GET")
+	auth.HandleFunc("/ui/sync", a.DashboardSyncHandler).Methods("POST")
+	auth.HandleFunc("/ui/purge", a.DashboardPurgeHandler).Methods("POST")
+	auth.HandleFunc("/admin/albums", a.AlbumsHandler).Methods("GET")
+	auth.HandleFunc("/admin/sync", a.SyncHandler).Methods("POST")
+	auth.HandleFunc("/admin/purge", a.PurgeHandler).Methods("POST")
+	auth.HandleFunc("/admin/caches", a.CachesHandler).Methods("GET")
+	auth.HandleFunc("/admin/config", a.ConfigHandler).Methods("GET")
+	auth.HandleFunc("/admin/connections", a.ConnectionsHandler).Methods("GET")
+	auth.HandleFunc("/admin/traffic", a.TrafficHandler).Methods("GET")
+	auth.HandleFunc("/admin/readyz", a.health.AdminReadyzHandler).Methods("GET")
+	auth.Use(a.authMiddleware)
+
+	r.Use(requestIDMiddleware)
+	return r
+}
+
+// authMiddleware accepts a verified client certificate, or the admin token
+// as a bearer token or as the Basic auth password, which lets browsers open
+// the dashboard without JavaScript. POSTs from another origin are rejected
+// since a browser attaches Basic credentials and client certificates by itself.
+func (a *AdminServer) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLogger(r.Context())
+		hasCA := a.reloader.Current().Admin.ClientCAFile != ""
+		if a.token == "" && !hasCA {
+			http.Error(w, "admin API disabled, set admin.token or admin.clientCAFile", http.StatusForbidden)
+			return
+		}
+		if !a.authenticated(r) {
+			logger.Warnf("Rejected unauthenticated admin request for %s", r.URL.Path)
+			if a.token != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="immich-proxy admin"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPost && !sameOrigin(r) {
+			logger.Warnf("Rejected cross-origin admin request for %s", r.URL.Path)
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *AdminServer) authenticated(r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	if a.token == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, ok = r.BasicAuth()
+	}
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+// sameOrigin reports whether r was not sent by a page on another origin.
+// Clients other than browsers send neither header and pass.
+func sameOrigin(r *http.Request) bool {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == r.Host
+	}
+	return r.Header.Get("Sec-Fetch-Site") != "cross-site"
+}
+
+func (a *AdminServer) albums() AdminAlbumsResponse {
+	albums := a.albumsKeys.Albums()
+	status := a.albumsKeys.KeyStatus()
+	health := a.albumsKeys.KeyHealth()
+	resp := AdminAlbumsResponse{LastSync: a.albumsKeys.LastSync()}
+	byKey := make(map[string][]string)
+	for albumID, key := range albums {
+		meta, _ := a.albumsKeys.AlbumMeta(albumID)
+		if meta.Assets != nil {
+			assets := *meta.Assets
+			assets.IDs = nil // kept for webhooks, too long to list
+			meta.Assets = &assets
+		}
+		resp.Albums = append(resp.Albums, AdminAlbum{AlbumID: albumID, Key: hashKey(key), AlbumMeta: meta})
+		byKey[key] = append(byKey[key], albumID)
+	}
+	slices.SortFunc(resp.Albums, func(x, y AdminAlbum) int { return strings.Compare(x.AlbumID, y.AlbumID) })
+	for _, key := range a.albumsKeys.Keys() {
+		ids := byKey[key]
+		if ids == nil {
+			ids = []string{}
+		}
+		slices.Sort(ids)
+		k := AdminKey{Key: hashKey(key), KeySyncStatus: status[key], AlbumIDs: ids}
+		if h, ok := health[key]; ok {
+			k.Health = &h
+		}
+		resp.Keys = append(resp.Keys, k)
+	}
+	return resp
+}
+
+// AlbumsHandler processes requests to /admin/albums
+func (a *AdminServer) AlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.albums())
+}
+
+// sync runs a full album sync, detached from the request so a client that
+// gives up does not abort it halfway.
+func (a *AdminServer) sync(ctx context.Context) {
+	ctx = context.WithoutCancel(ctx)
+	a.albumsKeys.fetchAllAlbums(ctx, a.reloader.Current().Immich.URL)
+}
+
+// SyncHandler processes requests to /admin/sync
+func (a *AdminServer) SyncHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+	start := time.Now()
+	logger.Info("Album sync triggered from the admin API")
+	a.sync(r.Context())
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"albums":     a.albumsKeys.Len(),
+		"durationMs": msSince(start),
+		"keys":       a.albums().Keys,
+	})
+}
+
+// PurgeHandler processes requests to /admin/purge?album={id}&asset={id}
+func (a *AdminServer) PurgeHandler(w http.ResponseWriter, r *http.Request) {
+	albumID, assetID := r.FormValue("album"), r.FormValue("asset")
+	if albumID == "" && assetID == "" {
+		http.Error(w, "album or asset is required", http.StatusBadRequest)
+		return
+	}
+	requestLogger(r.Context()).Infof("Purging caches for album %q asset %q from the admin API", albumID, assetID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"purged": purgeCaches(albumID, assetID)})
+}
+
+// CachesHandler processes requests to /admin/caches
+func (a *AdminServer) CachesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, cacheUsage())
+}
+
+// ConfigHandler processes requests to /admin/config. Secrets are masked.
+func (a *AdminServer) ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, flattenConfig(a.reloader.Current().Masked()))
+}
+
+// ConnectionsHandler processes requests to /admin/connections
+func (a *AdminServer) ConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.stats.Connections())
+}
+
+// TrafficHandler processes requests to /admin/traffic?limit={n}
+func (a *AdminServer) TrafficHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultTopAlbums
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, http.StatusOK, a.stats.TopAlbums(limit))
+}
+
+// newAdminServer creates the admin listener, with TLS when cfg has a
+// certificate and client certificate verification when it has a CA.
+func newAdminServer(ctx context.Context, cfg AdminConfig, admin *AdminServer) (*http.Server, error) {
+	srv := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           NewAdminRouter(admin),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	if cfg.CertFile == "" {
+		return srv, nil
+	}
+	certs, err := newCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	srv.TLSConfig = &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if cfg.ClientCAFile != "" {
+		pool, err := loadCertPool(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		// certificates are optional so the bearer token keeps working
+		srv.TLSConfig.ClientCAs = pool
+		srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	certs.Watch(ctx)
+	return srv, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no PEM certificates in %s", path)
+	}
+	return pool, nil
+}
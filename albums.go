@@ -4,78 +4,221 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
+	"maps"
 	"net/http"
 	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type AlbumsKeys struct {
-	ApiKeys       []string
-	syncEnabled   bool              // whether to fetch albums asynchronously
-	immageBaseURL string            // base URL for Immich API
-	AlbumsKeys    map[string]string // map of album ID to API key
-	lock          sync.Mutex        // to protect AlbumsKeys
+	ApiKeys        []string
+	syncEnabled    bool                     // whether to fetch albums asynchronously
+	immageBaseURL  string                   // base URL for Immich API
+	AlbumsKeys     map[string]string        // map of album ID to API key
+	lock           sync.Mutex               // to protect ApiKeys, AlbumsKeys and the maps below
+	lastSync       time.Time                // completion time of the last fetchAllAlbums
+	keyStatus      map[string]KeySyncStatus // fetch outcome per API key
+	lastSeen       map[string]time.Time     // when each album was last found on its key
+	statePath      string                   // where SaveState writes the map, empty when disabled
+	synced         atomic.Bool              // set once the first fetchAllAlbums has completed
+	intervalCh     chan time.Duration       // refresh interval changes for the refresh loop
+	probes         *probeCache              // misses and album lists of GetAlbumKeyWithoutSync
+	meta           map[string]AlbumMeta     // what was listed about each mapped album
+	scope          []string                 // album lists to fetch per key, see SetSyncScope
+	sharedLinkOnly bool                     // keep only albums with a shared link
+	policy         *albumPolicy             // album rules, albums they refuse are never mapped
+	refused        map[string]refusedAlbum  // albums listed by a key but refused by its album rules
+	health         map[string]KeyHealth     // outcome of the last key check per API key
+	sessions       *loginSessions           // passwords and access tokens of logins in ApiKeys
+	keyCheckCh     chan time.Duration       // key check interval changes for the key monitor
+	checkPerms     atomic.Bool              // whether key checks also test album and asset read
+	onUpdate       func([]AlbumUpdate)      // called after a sync with the albums whose assets changed
+}
+
+const (
+	scopeOwned  = "owned"
+	scopeShared = "shared"
+)
+
+// AlbumMeta is what the proxy keeps about a mapped album for policy
+// decisions, as listed by its key.
+type AlbumMeta struct {
+	Name          string `json:"name"`
+	OwnerID       string `json:"ownerId"`
+	OwnerEmail    string `json:"ownerEmail,omitempty"`
+	Shared        bool   `json:"shared"`
+	HasSharedLink bool   `json:"hasSharedLink"`
+	UpdatedAt     string `json:"updatedAt,omitempty"`
+	// nil for albums restored from a state file written before it was kept
+	Assets *AlbumAssets `json:"assets,omitempty"`
+}
+
+// AlbumAssets is what a listing says about the assets of an album, to tell
+// when they changed between syncs.
+type AlbumAssets struct {
+	Count        int    `json:"count"`
+	LastModified string `json:"lastModified,omitempty"` // updatedAt of the latest asset
+	// IDs of the assets, kept while an OnAlbumsUpdated hook is set so it
+	// can tell which were added; nil when not known
+	IDs []string `json:"ids,omitempty"`
+}
+
+// changed reports whether the listings of two syncs differ.
+func (x AlbumAssets) changed(y AlbumAssets) bool {
+	return x.Count != y.Count || x.LastModified != y.LastModified
+}
+
+// Added returns the IDs in After but not in Before, or nil when the IDs
+// before are not known.
+func (u AlbumUpdate) Added() []string {
+	if u.Before.IDs == nil {
+		return nil
+	}
+	before := make(map[string]bool, len(u.Before.IDs))
+	for _, id := range u.Before.IDs {
+		before[id] = true
+	}
+	added := []string{}
+	for _, id := range u.After.IDs {
+		if !before[id] {
+			added = append(added, id)
+		}
+	}
+	return added
+}
+
+// AlbumUpdate is an album whose assets changed between two syncs.
+type AlbumUpdate struct {
+	AlbumID string
+	Name    string
+	Key     string // key the album is mapped to
+	Before  AlbumAssets
+	After   AlbumAssets
+}
+
+func newAlbumMeta(album AlbumInfo) AlbumMeta {
+	m := AlbumMeta{
+		Name:          album.AlbumName,
+		OwnerID:       album.OwnerId,
+		Shared:        album.Shared,
+		HasSharedLink: album.HasSharedLink,
+		UpdatedAt:     album.UpdatedAt,
+		Assets:        &AlbumAssets{Count: album.AssetCount, LastModified: album.LastModifiedAssetTimestamp},
+	}
+	if album.Owner != nil {
+		m.OwnerEmail = album.Owner.Email
+	}
+	return m
+}
+
+// refusedAlbum is an album a key listed but the album rules refuse for it,
+// so share-key requests for it are judged by that key's rules.
+type refusedAlbum struct {
+	key  string
+	name string
+	seen time.Time
+}
+
+// KeySyncStatus is the outcome of the album fetches for one API key.
+type KeySyncStatus struct {
+	Albums      int       `json:"albums"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastError   string    `json:"lastError,omitempty"`
+	LastErrorAt time.Time `json:"lastErrorAt"`
+	Failures    int       `json:"consecutiveFailures"`
 }
 
 func NewAlbumsKeys(keys []string, syncEnabled bool, immageBaseURL string) *AlbumsKeys {
 	return &AlbumsKeys{
 		ApiKeys:       keys,
 		AlbumsKeys:    make(map[string]string),
+		keyStatus:     make(map[string]KeySyncStatus),
+		lastSeen:      make(map[string]time.Time),
 		syncEnabled:   syncEnabled,
 		immageBaseURL: immageBaseURL,
+		intervalCh:    make(chan time.Duration, 1),
+		probes:        newProbeCache(defaultMissCacheTTL, defaultAlbumListCacheTTL),
+		meta:          make(map[string]AlbumMeta),
+		refused:       make(map[string]refusedAlbum),
+		scope:         []string{scopeOwned},
+		health:        make(map[string]KeyHealth),
+		keyCheckCh:    make(chan time.Duration, 1),
+		sessions:      newLoginSessions(immageBaseURL),
 	}
 }
 
-func (a *AlbumsKeys) setAlbumKey(albumId, key string) {
+// Keys returns a snapshot of the configured API keys.
+func (a *AlbumsKeys) Keys() []string {
 	a.lock.Lock()
 	defer a.lock.Unlock()
-	log.Debugf("Setting album key for album %s to %s", albumId, key)
-	if key == "" {
-		delete(a.AlbumsKeys, albumId)
-	} else {
-		a.AlbumsKeys[albumId] = key
+	return slices.Clone(a.ApiKeys)
+}
+
+// SetAPIKeys swaps the configured API keys. Albums mapped to a key that is no
+// longer configured are dropped and will be found again by the next lookup or sync.
+func (a *AlbumsKeys) SetAPIKeys(keys []string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.ApiKeys = slices.Clone(keys)
+	for albumId, key := range a.AlbumsKeys {
+		if !slices.Contains(keys, key) {
+			delete(a.AlbumsKeys, albumId)
+			delete(a.lastSeen, albumId)
+			delete(a.meta, albumId)
+		}
 	}
+	for albumId, r := range a.refused {
+		if !slices.Contains(keys, r.key) {
+			delete(a.refused, albumId)
+		}
+	}
+	for key := range a.keyStatus {
+		if !slices.Contains(keys, key) {
+			delete(a.keyStatus, key)
+		}
+	}
+	for key := range a.health {
+		if !slices.Contains(keys, key) {
+			delete(a.health, key)
+			forgetKeyHealthMetrics(key)
+		}
+	}
+}
+
+func (a *AlbumsKeys) setAlbumKey(album AlbumInfo, key string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	log.Debugf("Setting album key for album %s to %s", album.ID, redactKey(key))
+	a.AlbumsKeys[album.ID] = key
+	a.lastSeen[album.ID] = time.Now()
+	a.meta[album.ID] = newAlbumMeta(album)
 }
 
-func (a *AlbumsKeys) GetAlbumKey(albumId string) string {
+func (a *AlbumsKeys) GetAlbumKey(ctx context.Context, albumId string) string {
 	key := a.getAlbumKeyFromMap(albumId)
 	if key == "" && !a.syncEnabled {
 		log.Debugf("Album key for %s not found in map, fetching without sync", albumId)
-		key = a.GetAlbumKeyWithoutSync(albumId)
+		key = a.GetAlbumKeyWithoutSync(ctx, albumId)
 		if key == "" {
 			log.Warnf("No API key found for album %s", albumId)
 			return ""
 		}
 	} else if key == "" {
-		log.Debugf("Album key for %s found in map: %s", albumId, key)
+		log.Debugf("Album key for %s not found in map, waiting for sync", albumId)
 	} else {
-		log.Debugf("Using cached album key for %s: %s", albumId, key)
+		log.Debugf("Using cached album key for %s: %s", albumId, redactKey(key))
 	}
 
 	return key
 }
 
-func (a *AlbumsKeys) GetAlbumKeyWithoutSync(albumId string) string {
-	for _, key := range a.ApiKeys {
-		log.Debugf("Fetching album %s with API key %s", albumId, key)
-		albums, err := a.getAlbums(a.immageBaseURL, key)
-		if err != nil {
-			log.Errorf("Failed to fetch albums for API key %s: %v", key, err)
-			continue
-		}
-		if slices.Contains(albums, albumId) {
-			a.setAlbumKey(albumId, key)
-			return key
-		}
-	}
-
-	return ""
-}
-
 func (a *AlbumsKeys) getAlbumKeyFromMap(albumId string) string {
 	a.lock.Lock()
 	defer a.lock.Unlock()
@@ -87,24 +230,293 @@ func (a *AlbumsKeys) getAlbumKeyFromMap(albumId string) string {
 	return key
 }
 
-func (a *AlbumsKeys) fetchAllAlbums(baseUrl string) {
+func (a *AlbumsKeys) Len() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return len(a.AlbumsKeys)
+}
+
+// Albums returns a snapshot of the album ID to API key map.
+func (a *AlbumsKeys) Albums() map[string]string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return maps.Clone(a.AlbumsKeys)
+}
+
+// KeyStatus returns a snapshot of the fetch outcome per API key.
+func (a *AlbumsKeys) KeyStatus() map[string]KeySyncStatus {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return maps.Clone(a.keyStatus)
+}
+
+func (a *AlbumsKeys) recordKeyResult(key string, albums int, err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	st := a.keyStatus[key]
+	if err != nil {
+		st.LastError = err.Error()
+		st.LastErrorAt = time.Now()
+		st.Failures++
+	} else {
+		st.Albums = albums
+		st.LastSuccess = time.Now()
+		st.LastError = ""
+		st.Failures = 0
+	}
+	a.keyStatus[key] = st
+}
+
+// Name implements Purger.
+func (a *AlbumsKeys) Name() string {
+	return "albumKeys"
+}
+
+// PurgeAlbum forgets which key owns albumId. Without sync the next request
+// looks it up again; with sync it is mapped again by the next sync.
+func (a *AlbumsKeys) PurgeAlbum(albumId string) int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, ok := a.AlbumsKeys[albumId]; !ok {
+		return 0
+	}
+	delete(a.AlbumsKeys, albumId)
+	delete(a.lastSeen, albumId)
+	delete(a.meta, albumId)
+	return 1
+}
+
+// PurgeAsset implements Purger; assets are not cached here.
+func (a *AlbumsKeys) PurgeAsset(string) int {
+	return 0
+}
+
+// HasSynced reports whether at least one fetchAllAlbums has completed.
+func (a *AlbumsKeys) HasSynced() bool {
+	return a.synced.Load()
+}
+
+func (a *AlbumsKeys) LastSync() time.Time {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.lastSync
+}
+
+// fetchAllAlbums lists the albums visible to each API key, reconciles the
+// album->key map with them and returns the albums listed per key.
+func (a *AlbumsKeys) fetchAllAlbums(ctx context.Context, baseUrl string) map[string][]AlbumInfo {
 	var wg sync.WaitGroup
-	log.Debugf("Fetching all albums from %s with %d API keys", baseUrl, len(a.ApiKeys))
-	for _, key := range a.ApiKeys {
+	var resultsLock sync.Mutex // to protect results and assetIDs
+	results := make(map[string][]AlbumInfo)
+	var assetIDs map[string][]string
+	a.lock.Lock()
+	if a.onUpdate != nil {
+		assetIDs = make(map[string][]string)
+	}
+	a.lock.Unlock()
+	var failures atomic.Int32
+	start := time.Now()
+	keys := a.Keys()
+	ctx, span := tracer.Start(ctx, "albums.sync",
+		trace.WithAttributes(attribute.Int("immich.api_keys", len(keys))))
+	defer span.End()
+	log.Debugf("Fetching all albums from %s with %d API keys", baseUrl, len(keys))
+	for _, key := range keys {
 		wg.Add(1)
 		go func(apiKey string) {
 			defer wg.Done()
-			albums, err := a.getAlbums(baseUrl, apiKey)
+			albums, err := a.listAlbums(ctx, baseUrl, apiKey)
+			a.recordKeyResult(apiKey, len(albums), err)
 			if err != nil {
-				log.Errorf("Failed to fetch albums for API key %s: %v", apiKey, err)
+				log.Errorf("Failed to fetch albums for API key %s: %v", redactKey(apiKey), err)
+				failures.Add(1)
+				apiKeyFetchFailures.WithLabelValues(hashKey(apiKey)).Inc()
+				if hasStatus(err, http.StatusUnauthorized) {
+					a.RevokeKey(apiKey, err)
+				}
 				return
 			}
-			for _, albumId := range albums {
-				a.setAlbumKey(albumId, apiKey)
+			a.keyAccepted(apiKey)
+			var ids map[string][]string
+			if assetIDs != nil {
+				ids = a.listAssetIDs(ctx, apiKey, albums)
 			}
+			resultsLock.Lock()
+			results[apiKey] = albums
+			maps.Copy(assetIDs, ids)
+			resultsLock.Unlock()
 		}(key)
 	}
 	wg.Wait()
+	diff := a.reconcile(results, assetIDs)
+	a.pruneRefused(results, start)
+
+	outcome := "success"
+	n := int(failures.Load())
+	switch {
+	case n == 0:
+		albumsSyncLastSuccess.SetToCurrentTime()
+	case n < len(keys):
+		outcome = "partial"
+	default:
+		outcome = "failure"
+	}
+	albumsSyncDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	a.lock.Lock()
+	a.lastSync = time.Now()
+	a.lock.Unlock()
+	a.synced.Store(true)
+	albumsSyncChanges.WithLabelValues("added").Add(float64(len(diff.Added)))
+	albumsSyncChanges.WithLabelValues("removed").Add(float64(len(diff.Removed)))
+	albumsSyncChanges.WithLabelValues("changed").Add(float64(len(diff.Changed)))
+	albumsSyncChanges.WithLabelValues("updated").Add(float64(len(diff.Updated)))
+	a.lock.Lock()
+	onUpdate := a.onUpdate
+	a.lock.Unlock()
+	if onUpdate != nil && len(diff.Updated) > 0 {
+		onUpdate(diff.Updated)
+	}
+	log.Infof("Albums sync %s in %s: %d albums, %s, %d/%d keys ok",
+		outcome, time.Since(start).Truncate(time.Millisecond), a.Len(), diff, len(keys)-n, len(keys))
+	span.SetAttributes(attribute.String("immich.sync_outcome", outcome), attribute.Int("immich.albums", a.Len()))
+	if outcome != "success" {
+		span.SetStatus(codes.Error, outcome)
+	}
+	if err := a.SaveState(); err != nil {
+		log.Errorf("Failed to save album state: %v", err)
+	}
+	return results
+}
+
+// albumsDiff is what a sync changed in the album->key map.
+type albumsDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string // albums now owned by another key
+	Updated []AlbumUpdate
+}
+
+func (d albumsDiff) String() string {
+	return fmt.Sprintf("+%d added, -%d removed, ~%d changed owner, %d with changed assets",
+		len(d.Added), len(d.Removed), len(d.Changed), len(d.Updated))
+}
+
+// reconcile rebuilds the album->key map from the albums listed per key and
+// swaps it in at once. An album keeps its owner while that key still lists
+// it, otherwise it goes to the first configured key listing it. Albums of
+// keys whose fetch failed are kept as they are; any other album no key
+// lists anymore is removed. assetIDs holds the asset IDs of listed albums,
+// where known.
+func (a *AlbumsKeys) reconcile(listed map[string][]AlbumInfo, assetIDs map[string][]string) albumsDiff {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	next := make(map[string]string, len(a.AlbumsKeys))
+	seen := make(map[string]time.Time, len(a.AlbumsKeys))
+	meta := make(map[string]AlbumMeta, len(a.AlbumsKeys))
+	for albumId, key := range a.AlbumsKeys {
+		if _, fetched := listed[key]; !fetched && slices.Contains(a.ApiKeys, key) {
+			next[albumId] = key
+			seen[albumId] = a.lastSeen[albumId]
+			meta[albumId] = a.meta[albumId]
+		}
+	}
+	now := time.Now()
+	for _, key := range a.ApiKeys {
+		for _, album := range listed[key] {
+			if a.AlbumsKeys[album.ID] == key {
+				next[album.ID] = key
+			}
+			seen[album.ID] = now
+		}
+	}
+	var diff albumsDiff
+	for _, key := range a.ApiKeys {
+		for _, album := range listed[key] {
+			if _, ok := next[album.ID]; !ok {
+				next[album.ID] = key
+			}
+			if next[album.ID] != key {
+				continue
+			}
+			m := newAlbumMeta(album)
+			m.Assets.IDs = assetIDs[album.ID]
+			if old := a.meta[album.ID].Assets; old != nil && old.changed(*m.Assets) {
+				diff.Updated = append(diff.Updated, AlbumUpdate{
+					AlbumID: album.ID, Name: album.AlbumName, Key: key, Before: *old, After: *m.Assets,
+				})
+			}
+			meta[album.ID] = m
+		}
+	}
+
+	for albumId, key := range next {
+		old, ok := a.AlbumsKeys[albumId]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, albumId)
+			log.Debugf("Album %s added on %s", albumId, redactKey(key))
+		case old != key:
+			diff.Changed = append(diff.Changed, albumId)
+			log.Debugf("Album %s moved from %s to %s", albumId, redactKey(old), redactKey(key))
+		}
+	}
+	for albumId, key := range a.AlbumsKeys {
+		if _, ok := next[albumId]; !ok {
+			diff.Removed = append(diff.Removed, albumId)
+			log.Debugf("Album %s removed from %s", albumId, redactKey(key))
+		}
+	}
+	a.AlbumsKeys = next
+	a.lastSeen = seen
+	a.meta = meta
+	return diff
+}
+
+// listAssetIDs returns the asset IDs of the albums key listed. IDs already
+// known are reused while the listing of the album is unchanged; the others
+// are fetched, and left out when that fails.
+func (a *AlbumsKeys) listAssetIDs(ctx context.Context, key string, albums []AlbumInfo) map[string][]string {
+	client := NewIMMICHClient(a.immageBaseURL, a)
+	ids := make(map[string][]string, len(albums))
+	for _, album := range albums {
+		listing := newAlbumMeta(album).Assets
+		if old, ok := a.AlbumMeta(album.ID); ok && old.Assets != nil && old.Assets.IDs != nil && !old.Assets.changed(*listing) {
+			ids[album.ID] = old.Assets.IDs
+			continue
+		}
+		var info AlbumInfo
+		endpoint := fmt.Sprintf("/albums/%s?withoutAssets=false", album.ID)
+		if err := client.request(ctx, endpoint, http.MethodGet, key, nil, &info); err != nil {
+			log.Warnf("Failed to list the assets of album %s: %v", album.ID, err)
+			continue
+		}
+		list := make([]string, 0, len(info.Assets))
+		for _, asset := range info.Assets {
+			list = append(list, asset.ID)
+		}
+		ids[album.ID] = list
+	}
+	return ids
+}
+
+// noteRefused records that key listed album but the album rules refuse it.
+func (a *AlbumsKeys) noteRefused(key string, album AlbumInfo) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.refused[album.ID] = refusedAlbum{key: key, name: album.AlbumName, seen: time.Now()}
+}
+
+// pruneRefused forgets refused albums that the keys fetched in listed no
+// longer listed since start, and those of keys no longer configured.
+func (a *AlbumsKeys) pruneRefused(listed map[string][]AlbumInfo, start time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for albumId, r := range a.refused {
+		_, fetched := listed[r.key]
+		if (fetched && r.seen.Before(start)) || !slices.Contains(a.ApiKeys, r.key) {
+			delete(a.refused, albumId)
+		}
+	}
 }
 
 func (a *AlbumsKeys) StartRefreshing(ctx context.Context,
@@ -114,15 +526,26 @@ func (a *AlbumsKeys) StartRefreshing(ctx context.Context,
 		return
 	}
 
-	ticker := time.NewTicker(refreshInterval)
-	defer ticker.Stop()
 	log.Infof("Starting albums refresh every %s", refreshInterval)
-	a.fetchAllAlbums(immichUrl) // Initial fetch
+	// with albums restored from the state file the proxy can serve right
+	// away, so the initial fetch does not hold up startup
+	warm := a.Len() > 0
+	if !warm {
+		a.fetchAllAlbums(ctx, immichUrl) // Initial fetch
+	}
+	ticker := time.NewTicker(refreshInterval)
 	go func() {
+		defer ticker.Stop()
+		if warm {
+			a.fetchAllAlbums(ctx, immichUrl)
+		}
 		for {
 			select {
 			case <-ticker.C:
-				a.fetchAllAlbums(immichUrl)
+				a.fetchAllAlbums(ctx, immichUrl)
+			case d := <-a.intervalCh:
+				log.Infof("Albums refresh interval changed to %s", d)
+				ticker.Reset(d)
 			case <-ctx.Done():
 				return
 			}
@@ -130,16 +553,179 @@ func (a *AlbumsKeys) StartRefreshing(ctx context.Context,
 	}()
 }
 
-func (a *AlbumsKeys) getAlbums(immichUrl, key string) ([]string, error) {
+// SetSyncScope sets which albums of each key are mapped: scopeOwned and/or
+// scopeShared lists, narrowed to albums with a shared link if sharedLinkOnly.
+// It applies from the next sync or lookup.
+func (a *AlbumsKeys) SetSyncScope(scope []string, sharedLinkOnly bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.scope = slices.Clone(scope)
+	a.sharedLinkOnly = sharedLinkOnly
+}
+
+// SetAlbumRules replaces the album rules and drops mapped albums they
+// refuse, so they answer 404 right away.
+func (a *AlbumsKeys) SetAlbumRules(rules []AlbumRule) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.policy = newAlbumPolicy(rules)
+	dropped := 0
+	for albumId, key := range a.AlbumsKeys {
+		if !a.policy.Allowed(key, albumId, a.meta[albumId].Name) {
+			a.refused[albumId] = refusedAlbum{key: key, name: a.meta[albumId].Name, seen: time.Now()}
+			delete(a.AlbumsKeys, albumId)
+			delete(a.lastSeen, albumId)
+			delete(a.meta, albumId)
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		log.Infof("Dropped %d album(s) refused by the album rules", dropped)
+	}
+}
+
+// AlbumAllowed reports whether the album rules allow serving albumId, named
+// name. A mapped album is checked with its key and stored name, and an
+// album a key listed but its rules refused with that key. Any other album
+// is looked up with the keys first when sync is off or there are rules for
+// single keys, as it may be one of theirs that the last sync did not see
+// yet; if that lookup fails with rules for single keys, it is refused.
+// Albums no key lists are checked against the rules for every key only.
+func (a *AlbumsKeys) AlbumAllowed(ctx context.Context, albumId, name string) bool {
+	a.lock.Lock()
+	_, mapped := a.AlbumsKeys[albumId]
+	_, refused := a.refused[albumId]
+	empty := a.policy.Empty()
+	keyRules := a.policy.hasKeyRules()
+	a.lock.Unlock()
+	if empty {
+		return true
+	}
+	complete := true
+	if !mapped && !refused && (!a.syncEnabled || keyRules) {
+		_, complete = a.lookupAlbumKey(ctx, albumId) // maps or refuses the album if a key lists it
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if key, ok := a.AlbumsKeys[albumId]; ok {
+		return a.policy.Allowed(key, albumId, a.meta[albumId].Name)
+	}
+	if r, ok := a.refused[albumId]; ok {
+		return a.policy.Allowed(r.key, albumId, r.name)
+	}
+	if !complete && a.policy.hasKeyRules() {
+		log.Warnf("Refusing album %s: not every API key could be asked whether it lists it", albumId)
+		return false
+	}
+	return a.policy.Allowed("", albumId, name)
+}
+
+// OnAlbumsUpdated sets fn to be called after each sync that found albums
+// whose assets changed. fn must not block.
+func (a *AlbumsKeys) OnAlbumsUpdated(fn func([]AlbumUpdate)) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.onUpdate = fn
+}
+
+// HasAlbumRules reports whether any album rules are configured.
+func (a *AlbumsKeys) HasAlbumRules() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return !a.policy.Empty()
+}
+
+// AlbumMeta returns what was listed about albumId, if it is mapped.
+func (a *AlbumsKeys) AlbumMeta(albumId string) (AlbumMeta, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	m, ok := a.meta[albumId]
+	return m, ok
+}
+
+// SetProbeCacheTTLs sets how long GetAlbumKeyWithoutSync remembers albums no
+// key can see and the album list of each key.
+func (a *AlbumsKeys) SetProbeCacheTTLs(missTTL, listTTL time.Duration) {
+	a.probes.setTTLs(missTTL, listTTL)
+}
+
+// SetRefreshInterval changes the interval of a running refresh loop.
+func (a *AlbumsKeys) SetRefreshInterval(d time.Duration) {
+	if !a.syncEnabled || d <= 0 {
+		return
+	}
+	// keep only the latest pending change
+	select {
+	case <-a.intervalCh:
+	default:
+	}
+	a.intervalCh <- d
+}
+
+func (a *AlbumsKeys) getAlbums(ctx context.Context, immichUrl, key string) ([]string, error) {
+	albums, err := a.listAlbums(ctx, immichUrl, key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, len(albums))
+	for i, album := range albums {
+		result[i] = album.ID
+	}
+	return result, nil
+}
+
+// listAlbums returns the albums in the sync scope of key, without their
+// assets: its own albums, albums shared with its user, or both, optionally
+// narrowed to albums with a shared link. Albums refused by the album rules
+// are left out.
+func (a *AlbumsKeys) listAlbums(ctx context.Context, immichUrl, key string) ([]AlbumInfo, error) {
+	a.lock.Lock()
+	scope, sharedLinkOnly, policy := a.scope, a.sharedLinkOnly, a.policy
+	a.lock.Unlock()
+
+	var albums []AlbumInfo
+	seen := make(map[string]bool)
+	for _, s := range scope {
+		list, err := a.requestAlbums(ctx, immichUrl, key, s == scopeShared)
+		if err != nil {
+			return nil, err
+		}
+		for _, album := range list {
+			if seen[album.ID] || (sharedLinkOnly && !album.HasSharedLink) {
+				continue
+			}
+			if !policy.Allowed(key, album.ID, album.AlbumName) {
+				log.Debugf("Album %s is refused by the album rules for %s", album.ID, redactKey(key))
+				a.noteRefused(key, album)
+				continue
+			}
+			seen[album.ID] = true
+			albums = append(albums, album)
+		}
+	}
+	return albums, nil
+}
+
+// requestAlbums calls /albums, or /albums?shared=true for the albums shared
+// with or by the key's user.
+func (a *AlbumsKeys) requestAlbums(ctx context.Context, immichUrl, key string, shared bool) ([]AlbumInfo, error) {
 	endpoint := "/albums"
+	if shared {
+		endpoint += "?shared=true"
+	}
 	url := fmt.Sprintf("%s/api%s", immichUrl, endpoint)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
 	}
-	req.Header.Set("x-api-key", key)
-	resp, err := http.DefaultClient.Do(req)
+	_, span := startUpstreamSpan(ctx, "immich.getAlbums", req, endpoint)
+	setRequestIDHeader(ctx, req)
+	start := time.Now()
+	resp, err := a.doAuthorized(req, key)
 	if err != nil {
+		observeUpstream(endpoint, start, 0, err)
+		endUpstreamSpan(span, 0, err)
 		return nil, fmt.Errorf("do request: %w", err)
 	}
 
@@ -148,25 +734,17 @@ func (a *AlbumsKeys) getAlbums(immichUrl, key string) ([]string, error) {
 			log.Warnf("failed to close response body: %v", err)
 		}
 	}()
+	observeUpstream(endpoint, start, resp.StatusCode, nil)
+	endUpstreamSpan(span, resp.StatusCode, nil)
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		b, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("immich api error on endpoint %s: %d %s: %s", url, resp.StatusCode, resp.Status, string(b))
+		return nil, newAPIError(url, resp)
 	}
 
-	type AlbumsResponse []struct {
-		ID string `json:"id"`
-	}
-
-	var albumsResp AlbumsResponse
+	var albumsResp []AlbumInfo
 
 	err = json.NewDecoder(resp.Body).Decode(&albumsResp)
 	if err != nil {
 		return nil, fmt.Errorf("decode response: %w", err)
 	}
-
-	result := make([]string, len(albumsResp))
-	for i, album := range albumsResp {
-		result[i] = album.ID
-	}
-	return result, nil
+	return albumsResp, nil
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	const key1, key2 = "key-one", "key-two"
+	album := func(id string, count int) AlbumInfo {
+		return AlbumInfo{ID: id, AlbumName: "Album " + id, AssetCount: count}
+	}
+	tests := []struct {
+		name    string
+		before  map[string][]AlbumInfo // listings of an earlier sync, if any
+		listed  map[string][]AlbumInfo
+		want    map[string]string
+		added   []string
+		removed []string
+		changed []string
+		updated []string
+	}{
+		{
+			name:   "first sync maps shared albums to the first key",
+			listed: map[string][]AlbumInfo{key1: {album("a1", 1), album("a2", 1)}, key2: {album("a2", 1), album("a3", 1)}},
+			want:   map[string]string{"a1": key1, "a2": key1, "a3": key2},
+			added:  []string{"a1", "a2", "a3"},
+		},
+		{
+			name:   "album keeps its key while it lists it",
+			before: map[string][]AlbumInfo{key2: {album("a1", 1)}},
+			listed: map[string][]AlbumInfo{key1: {album("a1", 1)}, key2: {album("a1", 1)}},
+			want:   map[string]string{"a1": key2},
+		},
+		{
+			name:    "album moves when its key no longer lists it",
+			before:  map[string][]AlbumInfo{key2: {album("a1", 1)}},
+			listed:  map[string][]AlbumInfo{key1: {album("a1", 1)}, key2: {}},
+			want:    map[string]string{"a1": key1},
+			changed: []string{"a1"},
+		},
+		{
+			name:    "album no key lists is removed",
+			before:  map[string][]AlbumInfo{key1: {album("a1", 1), album("a2", 1)}},
+			listed:  map[string][]AlbumInfo{key1: {album("a1", 1)}, key2: {}},
+			want:    map[string]string{"a1": key1},
+			removed: []string{"a2"},
+		},
+		{
+			name:   "albums of a key whose fetch failed are kept",
+			before: map[string][]AlbumInfo{key1: {album("a1", 1)}, key2: {album("a2", 1)}},
+			listed: map[string][]AlbumInfo{key1: {album("a1", 1)}},
+			want:   map[string]string{"a1": key1, "a2": key2},
+		},
+		{
+			name:    "changed asset count is reported",
+			before:  map[string][]AlbumInfo{key1: {album("a1", 1), album("a2", 1)}},
+			listed:  map[string][]AlbumInfo{key1: {album("a1", 2), album("a2", 1), album("a3", 5)}},
+			want:    map[string]string{"a1": key1, "a2": key1, "a3": key1},
+			added:   []string{"a3"},
+			updated: []string{"a1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAlbumsKeys([]string{key1, key2}, true, "http://immich.invalid")
+			if tt.before != nil {
+				a.reconcile(tt.before, nil)
+			}
+			diff := a.reconcile(tt.listed, nil)
+			if !maps.Equal(a.AlbumsKeys, tt.want) {
+				t.Errorf("album map = %v, want %v", a.AlbumsKeys, tt.want)
+			}
+			var updated []string
+			for _, u := range diff.Updated {
+				updated = append(updated, u.AlbumID)
+			}
+			for _, c := range []struct {
+				what      string
+				got, want []string
+			}{
+				{"added", diff.Added, tt.added},
+				{"removed", diff.Removed, tt.removed},
+				{"changed", diff.Changed, tt.changed},
+				{"updated", updated, tt.updated},
+			} {
+				slices.Sort(c.got)
+				if !slices.Equal(c.got, c.want) {
+					t.Errorf("%s = %v, want %v", c.what, c.got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAlbumUpdateAdded(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after []string
+		want          []string
+	}{
+		{name: "before unknown", before: nil, after: []string{"x"}, want: nil},
+		{name: "asset added", before: []string{"x", "y"}, after: []string{"y", "z", "x"}, want: []string{"z"}},
+		{name: "asset removed", before: []string{"x", "y"}, after: []string{"x"}, want: []string{}},
+		{name: "album was empty", before: []string{}, after: []string{"x", "y"}, want: []string{"x", "y"}},
+		{name: "same assets", before: []string{"x"}, after: []string{"x"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := AlbumUpdate{Before: AlbumAssets{IDs: tt.before}, After: AlbumAssets{IDs: tt.after}}
+			got := u.Added()
+			if (got == nil) != (tt.want == nil) || !slices.Equal(got, tt.want) {
+				t.Errorf("Added() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileKeepsAssetIDs(t *testing.T) {
+	const key = "key-one"
+	a := NewAlbumsKeys([]string{key}, true, "http://immich.invalid")
+	a.reconcile(map[string][]AlbumInfo{key: {{ID: "a1", AssetCount: 1}}}, map[string][]string{"a1": {"x"}})
+	diff := a.reconcile(map[string][]AlbumInfo{key: {{ID: "a1", AssetCount: 2}}}, map[string][]string{"a1": {"x", "y"}})
+	if len(diff.Updated) != 1 {
+		t.Fatalf("updated = %v, want a1", diff.Updated)
+	}
+	if added := diff.Updated[0].Added(); !slices.Equal(added, []string{"y"}) {
+		t.Errorf("Added() = %v, want [y]", added)
+	}
+	if meta, _ := a.AlbumMeta("a1"); !slices.Equal(meta.Assets.IDs, []string{"x", "y"}) {
+		t.Errorf("stored IDs = %v, want [x y]", meta.Assets.IDs)
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAuditMaxSizeMB  = 100
+	defaultAuditMaxBackups = 5
+)
+
+// AuditRecord is a single line of the audit log.
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"requestId,omitempty"`
+	ClientIP   string    `json:"clientIp"`
+	ShareKey   string    `json:"shareKey,omitempty"` // hashed, see hashKey
+	Kind       string    `json:"kind"`               // album, feed, frame, random, gallery, asset, thumbnail or original
+	AlbumID    string    `json:"albumId,omitempty"`
+	AssetID    string    `json:"assetId,omitempty"`
+	Bytes      int64     `json:"bytes"`
+	Status     int       `json:"status"`
+	DurationMs float64   `json:"durationMs"`
+}
+
+// AuditLogger appends AuditRecords to a JSONL file and rotates it by size.
+type AuditLogger struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	trusted    []*net.IPNet
+
+	lock   sync.Mutex // to protect file, size and closed
+	file   *os.File   // nil after a failed reopen, retried on the next write
+	size   int64
+	closed bool
+}
+
+func NewAuditLogger(cfg AuditConfig, trustedProxies []string) (*AuditLogger, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("audit path is empty")
+	}
+	trusted, err := parseCIDRs(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("parse trusted proxies: %w", err)
+	}
+	maxSizeMB := cfg.MaxSizeMB
+	if maxSizeMB <= 0 {
+		maxSizeMB = defaultAuditMaxSizeMB
+	}
+	maxBackups := cfg.MaxBackups
+	if maxBackups <= 0 {
+		maxBackups = defaultAuditMaxBackups
+	}
+	a := &AuditLogger{
+		path:       cfg.Path,
+		maxSize:    int64(maxSizeMB) << 20,
+		maxBackups: maxBackups,
+		trusted:    trusted,
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AuditLogger) open() error {
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("stat audit log: %w", err)
+	}
+	a.file = f
+	a.size = info.Size()
+	return nil
+}
+
+// rotate shifts path.N-1 -> path.N ... path -> path.1 and reopens path.
+// Must be called with lock held.
+func (a *AuditLogger) rotate() error {
+	if err := a.file.Close(); err != nil {
+		log.Warnf("failed to close audit log: %v", err)
+	}
+	a.file = nil
+	for i := a.maxBackups - 1; i >= 1; i-- {
+		src := fmt.Sprintf("%s.%d", a.path, i)
+		if _, err := os.Stat(src); err == nil {
+			if err := os.Rename(src, fmt.Sprintf("%s.%d", a.path, i+1)); err != nil {
+				log.Warnf("failed to rotate %s: %v", src, err)
+			}
+		}
+	}
+	if err := os.Rename(a.path, a.path+".1"); err != nil {
+		log.Warnf("failed to rotate %s: %v", a.path, err)
+	}
+	return a.open()
+}
+
+func (a *AuditLogger) Log(rec AuditRecord) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		log.Errorf("Failed to encode audit record: %v", err)
+		return
+	}
+	b = append(b, '\n')
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.closed {
+		return
+	}
+	if a.file == nil {
+		if err := a.open(); err != nil {
+			log.Errorf("Failed to reopen audit log: %v", err)
+			return
+		}
+	}
+	if a.size+int64(len(b)) > a.maxSize && a.size > 0 {
+		if err := a.rotate(); err != nil {
+			log.Errorf("Failed to rotate audit log: %v", err)
+			return
+		}
+	}
+	n, err := a.file.Write(b)
+	a.size += int64(n)
+	if err != nil {
+		log.Errorf("Failed to write audit record: %v", err)
+	}
+}
+
+func (a *AuditLogger) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.closed = true
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
+
+// Middleware records an AuditRecord of the given kind for every request
+// served by next.
+func (a *AuditLogger) Middleware(kind string, next http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		entry := AuditRecord{
+			Time:       start.UTC(),
+			RequestID:  RequestID(r.Context()),
+			ClientIP:   GetClientIP(r, a.trusted),
+			ShareKey:   hashKey(GetShareKey(r)),
+			Kind:       kind,
+			Bytes:      rec.bytes,
+			Status:     rec.status,
+			DurationMs: msSince(start),
+		}
+		switch kind {
+		case "album":
+			entry.AlbumID = GetAlbumID(r)
+		case "feed", "frame", "random", "gallery":
+			entry.AlbumID = mux.Vars(r)["id"]
+		default:
+			entry.AssetID = GetAssetID(r)
+		}
+		a.Log(entry)
+	}
+}
+
+// responseRecorder captures the status code and body size written by a handler.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// AuditFilter selects records when querying the audit log. Zero values match everything.
+type AuditFilter struct {
+	AlbumID  string
+	ShareKey string // hashed
+	Since    time.Time
+	Until    time.Time
+}
+
+func (f AuditFilter) Match(rec AuditRecord) bool {
+	if f.AlbumID != "" && rec.AlbumID != f.AlbumID {
+		return false
+	}
+	if f.ShareKey != "" && rec.ShareKey != f.ShareKey {
+		return false
+	}
+	if !f.Since.IsZero() && rec.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && rec.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// QueryAudit writes every record matching filter to out, oldest rotated file first.
+func QueryAudit(path string, maxBackups int, filter AuditFilter, out io.Writer) error {
+	if maxBackups <= 0 {
+		maxBackups = defaultAuditMaxBackups
+	}
+	files := make([]string, 0, maxBackups+1)
+	for i := maxBackups; i >= 1; i-- {
+		files = append(files, fmt.Sprintf("%s.%d", path, i))
+	}
+	files = append(files, path)
+
+	enc := json.NewEncoder(out)
+	for _, name := range files {
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var rec AuditRecord
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				log.Warnf("skipping malformed audit line in %s: %v", name, err)
+				continue
+			}
+			if filter.Match(rec) {
+				if err := enc.Encode(rec); err != nil {
+					_ = f.Close()
+					return err
+				}
+			}
+		}
+		err = scanner.Err()
+		if cerr := f.Close(); cerr != nil {
+			log.Warnf("failed to close %s: %v", name, cerr)
+		}
+		if err != nil {
+			return fmt.Errorf("read %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// parseAuditTime accepts RFC3339 timestamps or a duration relative to now (e.g. 24h).
+func parseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// runAuditCommand implements `immich-proxy audit query [flags]`.
+func runAuditCommand(opts *globalOptions, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "query" {
+		fmt.Fprintln(stderr, "usage: immich-proxy audit query [-file path] [-album id] [-key shareKey] [-since t] [-until t]")
+		return 2
+	}
+	fs := flag.NewFlagSet("audit query", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	opts.register(fs)
+	file := fs.String("file", "", "audit log path (defaults to audit.path from config)")
+	album := fs.String("album", "", "only records for this album ID")
+	key := fs.String("key", "", "only records for this share key")
+	since := fs.String("since", "", "only records after this time (RFC3339 or duration ago, e.g. 24h)")
+	until := fs.String("until", "", "only records before this time (RFC3339 or duration ago)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	maxBackups := 0
+	path := *file
+	if path == "" {
+		cfg, err := opts.loadConfig()
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to load config: %v\n", err)
+			return 1
+		}
+		path = cfg.Audit.Path
+		maxBackups = cfg.Audit.MaxBackups
+	}
+	if path == "" {
+		fmt.Fprintln(stderr, "no audit log path configured, use -file")
+		return 2
+	}
+
+	filter := AuditFilter{AlbumID: *album, ShareKey: hashKey(strings.TrimSpace(*key))}
+	var err error
+	if filter.Since, err = parseAuditTime(*since); err != nil {
+		fmt.Fprintf(stderr, "invalid -since: %v\n", err)
+		return 2
+	}
+	if filter.Until, err = parseAuditTime(*until); err != nil {
+		fmt.Fprintf(stderr, "invalid -until: %v\n", err)
+		return 2
+	}
+	if err := QueryAudit(path, maxBackups, filter, stdout); err != nil {
+		fmt.Fprintf(stderr, "query failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
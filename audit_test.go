@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// readAuditIDs returns the request IDs of the records in path, in order.
+func readAuditIDs(t *testing.T, path string) []string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, rec.RequestID)
+	}
+	return ids
+}
+
+// newTestAuditLogger returns a logger at dir/audit.jsonl whose files hold
+// two records that only have a request ID such as r1.
+func newTestAuditLogger(t *testing.T, dir string, maxBackups int) *AuditLogger {
+	t.Helper()
+	a, err := NewAuditLogger(AuditConfig{Path: filepath.Join(dir, "audit.jsonl"), MaxBackups: maxBackups}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	b, _ := json.Marshal(AuditRecord{RequestID: "r0"})
+	a.maxSize = int64(2 * (len(b) + 1))
+	return a
+}
+
+func TestAuditRotate(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestAuditLogger(t, dir, 2)
+	for i := 1; i <= 7; i++ {
+		a.Log(AuditRecord{RequestID: fmt.Sprintf("r%d", i)})
+	}
+	path := filepath.Join(dir, "audit.jsonl")
+	for name, want := range map[string][]string{
+		path:        {"r7"},
+		path + ".1": {"r5", "r6"},
+		path + ".2": {"r3", "r4"},
+	} {
+		if got := readAuditIDs(t, name); !slices.Equal(got, want) {
+			t.Errorf("%s holds %v, want %v", filepath.Base(name), got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists beyond maxBackups: %v", path, err)
+	}
+}
+
+func TestAuditReopensAfterFailedRotate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "audit")
+	if err := os.Mkdir(dir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	a := newTestAuditLogger(t, dir, 2)
+	a.Log(AuditRecord{RequestID: "r1"})
+	a.Log(AuditRecord{RequestID: "r2"})
+
+	// the rotation cannot reopen the file while its directory is gone
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	a.Log(AuditRecord{RequestID: "lost"})
+	if err := os.Mkdir(dir, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	a.Log(AuditRecord{RequestID: "r3"})
+	if got := readAuditIDs(t, filepath.Join(dir, "audit.jsonl")); !slices.Equal(got, []string{"r3"}) {
+		t.Errorf("audit log holds %v after the directory came back, want [r3]", got)
+	}
+
+	a.Close()
+	a.Log(AuditRecord{RequestID: "after-close"})
+	if got := readAuditIDs(t, filepath.Join(dir, "audit.jsonl")); !slices.Equal(got, []string{"r3"}) {
+		t.Errorf("audit log holds %v after Close, want [r3]", got)
+	}
+}
+
+func TestQueryAudit(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestAuditLogger(t, dir, 5) // each record fills a file
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []struct {
+		id, album, shareKey string
+	}{
+		{"r1", "a1", "sk1"},
+		{"r2", "a2", "sk1"},
+		{"r3", "a1", "sk2"},
+		{"r4", "a1", ""},
+		{"r5", "a2", "sk2"},
+	}
+	for i, r := range records {
+		a.Log(AuditRecord{
+			Time:      start.Add(time.Duration(i) * time.Hour),
+			RequestID: r.id,
+			AlbumID:   r.album,
+			ShareKey:  hashKey(r.shareKey),
+		})
+	}
+	path := filepath.Join(dir, "audit.jsonl")
+	if err := os.WriteFile(path+".9", []byte("not json\n"), 0o640); err != nil {
+		t.Fatal(err) // beyond maxBackups, never read
+	}
+
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   []string
+	}{
+		{name: "everything, oldest first", want: []string{"r1", "r2", "r3", "r4", "r5"}},
+		{name: "album", filter: AuditFilter{AlbumID: "a1"}, want: []string{"r1", "r3", "r4"}},
+		{name: "share key", filter: AuditFilter{ShareKey: hashKey("sk2")}, want: []string{"r3", "r5"}},
+		{name: "album and share key", filter: AuditFilter{AlbumID: "a2", ShareKey: hashKey("sk1")}, want: []string{"r2"}},
+		{
+			name:   "time range, bounds included",
+			filter: AuditFilter{Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour)},
+			want:   []string{"r2", "r3", "r4"},
+		},
+		{name: "no match", filter: AuditFilter{AlbumID: "a9"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := QueryAudit(path, 5, tt.filter, &out); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			dec := json.NewDecoder(&out)
+			for dec.More() {
+				var rec AuditRecord
+				if err := dec.Decode(&rec); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, rec.RequestID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("QueryAudit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+)
+
+// Purger is an in-memory cache that can be inspected and purged from the
+// admin API.
+type Purger interface {
+	Name() string
+	Len() int
+	// PurgeAlbum and PurgeAsset drop the entries for the given ID and
+	// return how many were removed.
+	PurgeAlbum(albumID string) int
+	PurgeAsset(assetID string) int
+}
+
+// CacheUsage is the size of one registered cache.
+type CacheUsage struct {
+	Name    string `json:"name"`
+	Entries int    `json:"entries"`
+}
+
+var (
+	cachesLock sync.Mutex // to protect caches
+	caches     []Purger
+)
+
+// registerCache makes c visible to the admin API.
+func registerCache(c Purger) {
+	cachesLock.Lock()
+	defer cachesLock.Unlock()
+	caches = append(caches, c)
+}
+
+func registeredCaches() []Purger {
+	cachesLock.Lock()
+	defer cachesLock.Unlock()
+	return append([]Purger(nil), caches...)
+}
+
+// cacheUsage reports the size of every registered cache.
+func cacheUsage() []CacheUsage {
+	var usage []CacheUsage
+	for _, c := range registeredCaches() {
+		usage = append(usage, CacheUsage{Name: c.Name(), Entries: c.Len()})
+	}
+	return usage
+}
+
+// purgeCaches drops albumID and/or assetID from every registered cache and
+// returns the number of entries removed per cache.
+func purgeCaches(albumID, assetID string) map[string]int {
+	purged := make(map[string]int)
+	for _, c := range registeredCaches() {
+		n := 0
+		if albumID != "" {
+			n += c.PurgeAlbum(albumID)
+		}
+		if assetID != "" {
+			n += c.PurgeAsset(assetID)
+		}
+		purged[c.Name()] = n
+	}
+	return purged
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+const cliTimeout = time.Minute
+
+const usage = `Usage: immich-proxy [global flags] <command> [args]
+
+Commands:
+  serve          run the proxy (default)
+  validate       check the config and exit non-zero on errors
+  albums list    run a one-shot album sync and print the album -> key table
+  keys check     test each API key against Immich
+  config print   print the effective config, secrets masked
+  config env     list the IMMICH_PROXY_* environment variables
+  audit query    filter the audit log
+  version        print the version
+
+Global flags (also accepted after the command):
+`
+
+// globalOptions are the flags shared by every command.
+type globalOptions struct {
+	configPath string
+	logLevel   string
+}
+
+func (o *globalOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.configPath, "config", o.configPath, "path to config file (or $"+configPathEnv+")")
+	fs.StringVar(&o.logLevel, "log-level", o.logLevel, "override logLevel from the config")
+}
+
+// loadConfig loads the config with flag overrides applied, which take
+// precedence over the environment.
+func (o *globalOptions) loadConfig() (*Config, error) {
+	cfg, err := loadConfig(o.configPath)
+	if err != nil {
+		return nil, err
+	}
+	if o.logLevel != "" {
+		cfg.LogLevel = o.logLevel
+	}
+	return cfg, nil
+}
+
+// loadValidConfig loads and validates the config and sets up logging from it.
+func (o *globalOptions) loadValidConfig() (*Config, error) {
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := setupLogging(cfg.LogLevel, cfg.LogFormat); err != nil {
+		return nil, err
+	}
+	registerSecrets(cfg.Secrets())
+	return cfg, nil
+}
+
+func runCLI(args []string, stdout, stderr io.Writer) int {
+	opts := &globalOptions{configPath: configPath()}
+	fs := flag.NewFlagSet("immich-proxy", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	opts.register(fs)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	rest := fs.Args()
+	cmd := "serve"
+	if len(rest) > 0 {
+		cmd, rest = rest[0], rest[1:]
+	}
+	// subcommand flag sets, which also accept the global flags
+	sub := func(name string) *flag.FlagSet {
+		sfs := flag.NewFlagSet(name, flag.ContinueOnError)
+		sfs.SetOutput(stderr)
+		opts.register(sfs)
+		return sfs
+	}
+	parse := func(sfs *flag.FlagSet, args []string) bool {
+		return sfs.Parse(args) == nil
+	}
+
+	switch cmd {
+	case "serve":
+		sfs := sub("serve")
+		if !parse(sfs, rest) {
+			return 2
+		}
+		cfg, err := opts.loadValidConfig()
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", opts.configPath, err)
+			return 1
+		}
+		if err := serve(opts, cfg); err != nil {
+			fmt.Fprintf(stderr, "serve: %v\n", err)
+			return 1
+		}
+		return 0
+	case "validate":
+		sfs := sub("validate")
+		if !parse(sfs, rest) {
+			return 2
+		}
+		return runValidateCommand(opts, stdout, stderr)
+	case "albums":
+		if len(rest) == 0 || rest[0] != "list" {
+			fmt.Fprintln(stderr, "usage: immich-proxy albums list")
+			return 2
+		}
+		sfs := sub("albums list")
+		if !parse(sfs, rest[1:]) {
+			return 2
+		}
+		return runAlbumsList(opts, stdout, stderr)
+	case "keys":
+		if len(rest) == 0 || rest[0] != "check" {
+			fmt.Fprintln(stderr, "usage: immich-proxy keys check")
+			return 2
+		}
+		sfs := sub("keys check")
+		if !parse(sfs, rest[1:]) {
+			return 2
+		}
+		return runKeysCheck(opts, stdout, stderr)
+	case "config":
+		if len(rest) == 0 {
+			fmt.Fprintln(stderr, "usage: immich-proxy config print|env")
+			return 2
+		}
+		sfs := sub("config " + rest[0])
+		if !parse(sfs, rest[1:]) {
+			return 2
+		}
+		return runConfigCommand(opts, rest[0], stdout, stderr)
+	case "audit":
+		return runAuditCommand(opts, rest, stdout, stderr)
+	case "version":
+		fmt.Fprintf(stdout, "immich-proxy %s\n", versionString())
+		return 0
+	case "help":
+		fs.Usage()
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n", cmd)
+		fs.Usage()
+		return 2
+	}
+}
+
+// versionString returns version plus the VCS revision when it is known.
+func versionString() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	var rev, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				modified = "-dirty"
+			}
+		}
+	}
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	if rev == "" {
+		return fmt.Sprintf("%s (%s)", version, info.GoVersion)
+	}
+	return fmt.Sprintf("%s (%s%s, %s)", version, rev, modified, info.GoVersion)
+}
+
+// runAlbumsList implements `immich-proxy albums list`.
+func runAlbumsList(opts *globalOptions, stdout, stderr io.Writer) int {
+	cfg, err := opts.loadValidConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", opts.configPath, err)
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
+	defer cancel()
+
+	albumsKeys := NewAlbumsKeys(cfg.BackendKeys(), true, cfg.Immich.URL)
+	albumsKeys.SetLogins(cfg.Immich.Logins)
+	albumsKeys.SetSyncScope(cfg.Immich.SyncScope, cfg.Immich.SharedLinkOnly)
+	albumsKeys.SetAlbumRules(cfg.AlbumRules)
+	client := NewIMMICHClient(cfg.Immich.URL, albumsKeys)
+	perKey := albumsKeys.fetchAllAlbums(ctx, cfg.Immich.URL)
+
+	// label owners with the email of the key's user when they match
+	owners := make(map[string]string)
+	albums := make(map[string]AlbumInfo)
+	for key, list := range perKey {
+		if user, err := client.GetMyUser(ctx, key); err == nil {
+			owners[user.ID] = user.Email
+		}
+		for _, album := range list {
+			albums[album.ID] = album
+		}
+	}
+	ids := make([]string, 0, len(albums))
+	for id := range albums {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return albums[ids[i]].AlbumName < albums[ids[j]].AlbumName })
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ALBUM ID\tNAME\tKEY\tOWNER\tSHARED\tSHARED LINK")
+	for _, id := range ids {
+		album := albums[id]
+		owner := album.OwnerId
+		if album.Owner != nil && album.Owner.Email != "" {
+			owner = album.Owner.Email
+		} else if email, ok := owners[owner]; ok {
+			owner = email
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%t\n", id, album.AlbumName,
+			hashKey(albumsKeys.getAlbumKeyFromMap(id)), owner, album.Shared, album.HasSharedLink)
+	}
+	if err := tw.Flush(); err != nil {
+		fmt.Fprintf(stderr, "write: %v\n", err)
+		return 1
+	}
+	if keys := albumsKeys.Keys(); len(perKey) < len(keys) {
+		fmt.Fprintf(stderr, "%d of %d API keys failed, see log\n", len(keys)-len(perKey), len(keys))
+		return 1
+	}
+	return 0
+}
+
+// runKeysCheck implements `immich-proxy keys check`. It exits non-zero if any key fails.
+func runKeysCheck(opts *globalOptions, stdout, stderr io.Writer) int {
+	cfg, err := opts.loadValidConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", opts.configPath, err)
+		return 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
+	defer cancel()
+
+	albumsKeys := NewAlbumsKeys(cfg.BackendKeys(), false, cfg.Immich.URL)
+	albumsKeys.SetLogins(cfg.Immich.Logins)
+	albumsKeys.SetSyncScope(cfg.Immich.SyncScope, cfg.Immich.SharedLinkOnly)
+	albumsKeys.SetAlbumRules(cfg.AlbumRules)
+	client := NewIMMICHClient(cfg.Immich.URL, albumsKeys)
+	failed := 0
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSTATUS\tUSER\tALBUMS\tERROR")
+	for _, key := range albumsKeys.Keys() {
+		// classified like the key monitor: a 403 is a valid key restricted
+		// to album and asset permissions, which may not read its own user
+		email := "-"
+		user, err := client.GetMyUser(ctx, key)
+		switch {
+		case hasStatus(err, http.StatusUnauthorized):
+			failed++
+			fmt.Fprintf(tw, "%s\tinvalid\t-\t-\t%v\n", hashKey(key), err)
+			continue
+		case hasStatus(err, http.StatusForbidden):
+		case err != nil:
+			failed++
+			fmt.Fprintf(tw, "%s\terror\t-\t-\t%v\n", hashKey(key), err)
+			continue
+		default:
+			email = user.Email
+		}
+		albums, err := albumsKeys.getAlbums(ctx, cfg.Immich.URL, key)
+		if err != nil {
+			failed++
+			fmt.Fprintf(tw, "%s\tno album access\t%s\t-\t%v\n", hashKey(key), email, err)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\tok\t%s\t%d\t\n", hashKey(key), email, len(albums))
+	}
+	if err := tw.Flush(); err != nil {
+		fmt.Fprintf(stderr, "write: %v\n", err)
+		return 1
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
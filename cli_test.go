@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunKeysCheck(t *testing.T) {
+	// /users/me answers by key: revoked keys get a 401, keys restricted to
+	// album and asset permissions a 403
+	usersMe := map[string]int{"good": http.StatusOK, "restricted": http.StatusForbidden, "revoked": http.StatusUnauthorized, "flaky": http.StatusBadGateway}
+	immich := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("x-api-key")
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/users/me":
+			status := usersMe[key]
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				w.Write([]byte(`{"message":"no"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(UserInfo{ID: "u1", Email: "good@example.com"})
+		case "/api/albums":
+			w.Write([]byte(`[{"id":"a1","albumName":"Trip"}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer immich.Close()
+
+	tests := []struct {
+		name string
+		keys []string
+		want map[string]string // key -> status column
+		code int
+	}{
+		{
+			name: "valid and restricted keys pass",
+			keys: []string{"good", "restricted"},
+			want: map[string]string{"good": "ok", "restricted": "ok"},
+		},
+		{
+			name: "revoked and failing keys fail",
+			keys: []string{"good", "revoked", "flaky"},
+			want: map[string]string{"good": "ok", "revoked": "invalid", "flaky": "error"},
+			code: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			config := "immich:\n  url: " + immich.URL + "\n  api_keys: [" + strings.Join(tt.keys, ", ") + "]\n"
+			if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			var stdout, stderr bytes.Buffer
+			code := runKeysCheck(&globalOptions{configPath: path}, &stdout, &stderr)
+			if code != tt.code {
+				t.Errorf("exit code %d, want %d\n%s%s", code, tt.code, stdout.String(), stderr.String())
+			}
+			for key, status := range tt.want {
+				var row []string
+				for _, line := range strings.Split(stdout.String(), "\n") {
+					if strings.HasPrefix(line, hashKey(key)) {
+						row = strings.Fields(line)
+					}
+				}
+				if len(row) < 2 || row[1] != status {
+					t.Errorf("key %s: row %q, want status %s", key, row, status)
+				}
+			}
+		})
+	}
+}
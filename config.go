@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"slices"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
@@ -9,14 +13,35 @@ import (
 
 type Config struct {
 	Immich struct {
-		URL                   string   `yaml:"url"`
-		APIKeys               []string `yaml:"api_keys"`
-		AlbumsSyncEnabled     bool     `yaml:"albumsSyncEnabled,omitempty"`
-		AlbumsRefreshInterval string   `yaml:"albumsRefreshInterval,omitempty"`
+		URL                   string        `yaml:"url"`
+		APIKeys               []string      `yaml:"api_keys" secret:"true"` // literal keys or env:NAME / file:PATH references
+		Logins                []LoginConfig `yaml:"logins,omitempty"`       // users to log in as instead of API keys
+		AlbumsSyncEnabled     bool          `yaml:"albumsSyncEnabled,omitempty"`
+		AlbumsRefreshInterval string        `yaml:"albumsRefreshInterval,omitempty"`
+		SyncScope             []string      `yaml:"syncScope,omitempty"`      // owned (default) and/or shared
+		SharedLinkOnly        bool          `yaml:"sharedLinkOnly,omitempty"` // only map albums with a shared link
+		// lookups without sync: how long unknown albums and each key's album list are cached
+		MissCacheTTL      string `yaml:"missCacheTTL,omitempty"`
+		AlbumListCacheTTL string `yaml:"albumListCacheTTL,omitempty"`
+		// how often each API key is validated, 0 disables; permissions are checked too if set
+		KeyCheckInterval    string `yaml:"keyCheckInterval,omitempty"`
+		CheckKeyPermissions bool   `yaml:"checkKeyPermissions,omitempty"`
 	} `yaml:"immich"`
-	Listen   string     `yaml:"listen"`
-	LogLevel string     `yaml:"logLevel"`
-	Cors     CORSConfig `yaml:"cors,omitempty"`
+	AlbumRules     []AlbumRule   `yaml:"albumRules,omitempty"` // which albums may be served, per key or for all
+	Listen         string        `yaml:"listen"`
+	PublicURL      string        `yaml:"publicURL,omitempty"` // how clients reach the proxy, for absolute links
+	LogLevel       string        `yaml:"logLevel"`
+	LogFormat      string        `yaml:"logFormat,omitempty"` // text (default) or json
+	Cors           CORSConfig    `yaml:"cors,omitempty"`
+	Audit          AuditConfig   `yaml:"audit,omitempty"`
+	TLS            TLSConfig     `yaml:"tls,omitempty"`
+	Admin          AdminConfig   `yaml:"admin,omitempty"`
+	Tracing        TracingConfig `yaml:"tracing,omitempty"`
+	Webhooks       WebhookConfig `yaml:"webhooks,omitempty"`
+	TrustedProxies []string      `yaml:"trustedProxies,omitempty"` // CIDRs allowed to set X-Forwarded-For
+	StateFile      string        `yaml:"stateFile,omitempty"`      // album->key map kept across restarts, empty disables
+	// how long in-flight requests may take to drain on SIGINT/SIGTERM
+	ShutdownTimeout string `yaml:"shutdownTimeout,omitempty"`
 }
 
 type CORSConfig struct {
@@ -26,22 +51,154 @@ type CORSConfig struct {
 	AllowCredentials bool   `yaml:"allowCredentials"`
 }
 
+type AuditConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Path       string `yaml:"path"`
+	MaxSizeMB  int    `yaml:"maxSizeMB,omitempty"`
+	MaxBackups int    `yaml:"maxBackups,omitempty"`
+}
+
+// TLSConfig enables HTTPS on listen when CertFile and KeyFile are set.
+type TLSConfig struct {
+	CertFile       string   `yaml:"certFile"`
+	KeyFile        string   `yaml:"keyFile"`
+	MinVersion     string   `yaml:"minVersion,omitempty"`     // 1.2 (default) or 1.3
+	CipherSuites   []string `yaml:"cipherSuites,omitempty"`   // crypto/tls names, TLS 1.2 only
+	DisableHTTP2   bool     `yaml:"disableHTTP2,omitempty"`   // HTTP/2 is negotiated by default
+	RedirectListen string   `yaml:"redirectListen,omitempty"` // e.g. :80, redirects plain HTTP to HTTPS
+}
+
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+type AdminConfig struct {
+	Listen string `yaml:"listen"` // e.g. 127.0.0.1:9090, empty disables the admin listener
+	// bearer token for the admin API and dashboard, literal or env:NAME / file:PATH reference
+	Token        string `yaml:"token,omitempty" secret:"true"`
+	CertFile     string `yaml:"certFile,omitempty"`     // serve the admin listener over TLS
+	KeyFile      string `yaml:"keyFile,omitempty"`      // key for certFile
+	ClientCAFile string `yaml:"clientCAFile,omitempty"` // accept client certificates signed by this CA (mTLS)
+}
+
+type TracingConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	Exporter    string `yaml:"exporter,omitempty"` // otlp (default) or stdout
+	Endpoint    string `yaml:"endpoint,omitempty"` // OTLP/HTTP URL, defaults to OTEL_EXPORTER_OTLP_ENDPOINT
+	ServiceName string `yaml:"serviceName,omitempty"`
+	// SampleRatio is the share of traces sampled, in (0, 1]. 0 or unset
+	// means 1, every trace.
+	SampleRatio float64 `yaml:"sampleRatio,omitempty"`
+}
+
+// loadConfig reads path, overlays IMMICH_PROXY_* environment variables,
+// applies defaults and resolves secret references. A missing default
+// config.yaml is not an error, so the proxy can be configured from the
+// environment alone. The result still needs Validate.
 func loadConfig(path string) (*Config, error) {
+	var cfg Config
+	if err := decodeConfigFile(path, &cfg); err != nil {
+		return nil, err
+	}
+	if err := applyEnvOverrides(&cfg, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	cfg.applyDefaults()
+	if err := cfg.resolveSecrets(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func decodeConfigFile(path string, cfg *Config) error {
 	f, err := os.Open(path)
+	if os.IsNotExist(err) && path == defaultConfigPath {
+		log.Debugf("No %s found, using environment only", path)
+		return nil
+	}
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer func() {
 		if err := f.Close(); err != nil {
 			log.Warnf("failed to close config file: %v", err)
 		}
 	}()
-	var cfg Config
 	dec := yaml.NewDecoder(f)
-	if err := dec.Decode(&cfg); err != nil {
-		return nil, err
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return err
 	}
-	return &cfg, nil
+	return nil
+}
+
+// resolveSecrets replaces secret references (env:, file:) with their values.
+func (c *Config) resolveSecrets() error {
+	keys, err := resolveAPIKeys(c.Immich.APIKeys)
+	if err != nil {
+		return err
+	}
+	c.Immich.APIKeys = keys
+	for i := range c.Immich.Logins {
+		l := &c.Immich.Logins[i]
+		if l.Email != "" {
+			if l.Email, err = resolveSecret(l.Email); err != nil {
+				return fmt.Errorf("immich.logins[%d].email: %w", i, err)
+			}
+		}
+		if l.Password != "" {
+			if l.Password, err = resolveSecret(l.Password); err != nil {
+				return fmt.Errorf("immich.logins[%d].password: %w", i, err)
+			}
+		}
+	}
+	if c.Webhooks.Secret != "" {
+		secret, err := resolveSecret(c.Webhooks.Secret)
+		if err != nil {
+			return fmt.Errorf("webhooks.secret: %w", err)
+		}
+		c.Webhooks.Secret = secret
+	}
+	if c.Admin.Token != "" {
+		token, err := resolveSecret(c.Admin.Token)
+		if err != nil {
+			return fmt.Errorf("admin.token: %w", err)
+		}
+		c.Admin.Token = token
+	}
+	return nil
+}
+
+// Secrets returns every resolved secret, for redaction from the logs.
+func (c *Config) Secrets() []string {
+	secrets := append(slices.Clone(c.Immich.APIKeys), c.Admin.Token, c.Webhooks.Secret)
+	for _, l := range c.Immich.Logins {
+		secrets = append(secrets, l.Password)
+	}
+	return secrets
+}
+
+// BackendKeys returns the API keys followed by a stand-in key for each
+// login, the list AlbumsKeys works with.
+func (c *Config) BackendKeys() []string {
+	keys := slices.Clone(c.Immich.APIKeys)
+	for _, l := range c.Immich.Logins {
+		keys = append(keys, loginKey(l.Email))
+	}
+	return keys
+}
+
+// ProbeCacheTTLs returns the validated immich.missCacheTTL and
+// immich.albumListCacheTTL.
+func (c *Config) ProbeCacheTTLs() (missTTL, listTTL time.Duration) {
+	missTTL, _ = time.ParseDuration(c.Immich.MissCacheTTL)
+	listTTL, _ = time.ParseDuration(c.Immich.AlbumListCacheTTL)
+	return missTTL, listTTL
+}
+
+// KeyCheckInterval returns the validated immich.keyCheckInterval.
+func (c *Config) KeyCheckInterval() time.Duration {
+	d, _ := time.ParseDuration(c.Immich.KeyCheckInterval)
+	return d
 }
 
 func (c *Config) GetCORSConfig() *CORSConfig {
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var dashboardTemplate = parseTemplate("admin.html")
+
+// dashboardMessages are the notices a form redirect may show, by the code
+// in its msg parameter. Only codes travel in the URL, so a link cannot make
+// the dashboard display text of its choosing; %d is the n parameter.
+var dashboardMessages = map[string]string{
+	"synced":     "Sync finished, %d albums mapped",
+	"purged":     "Purged %d cache entries",
+	"purgeEmpty": "Enter an album or asset ID to purge",
+}
+
+type dashboardData struct {
+	Message     string
+	SyncEnabled bool
+	Albums      AdminAlbumsResponse
+	Names       map[string]string // album ID -> name
+	Caches      []CacheUsage
+	TopAlbums   []AlbumTraffic
+	Connections ConnectionStats
+}
+
+// DashboardHandler processes requests to / on the admin listener
+func (a *AdminServer) DashboardHandler(w http.ResponseWriter, r *http.Request) {
+	albums := a.albums()
+	names := make(map[string]string, len(albums.Albums))
+	for _, album := range albums.Albums {
+		names[album.AlbumID] = album.Name
+	}
+	data := dashboardData{
+		Message:     dashboardMessage(r.URL.Query()),
+		SyncEnabled: a.reloader.Current().Immich.AlbumsSyncEnabled,
+		Albums:      albums,
+		Names:       names,
+		Caches:      cacheUsage(),
+		TopAlbums:   a.stats.TopAlbums(defaultTopAlbums),
+		Connections: a.stats.Connections(),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		requestLogger(r.Context()).Errorf("Failed to render dashboard: %v", err)
+	}
+}
+
+// DashboardSyncHandler processes the resync form of the dashboard
+func (a *AdminServer) DashboardSyncHandler(w http.ResponseWriter, r *http.Request) {
+	requestLogger(r.Context()).Info("Album sync triggered from the dashboard")
+	a.sync(r.Context())
+	redirectToDashboard(w, r, "synced", a.albumsKeys.Len())
+}
+
+// DashboardPurgeHandler processes the purge forms of the dashboard
+func (a *AdminServer) DashboardPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	albumID, assetID := r.FormValue("album"), r.FormValue("asset")
+	if albumID == "" && assetID == "" {
+		redirectToDashboard(w, r, "purgeEmpty", 0)
+		return
+	}
+	requestLogger(r.Context()).Infof("Purging caches for album %q asset %q from the dashboard", albumID, assetID)
+	total := 0
+	for _, n := range purgeCaches(albumID, assetID) {
+		total += n
+	}
+	redirectToDashboard(w, r, "purged", total)
+}
+
+// redirectToDashboard answers a form POST with a redirect, so reloading the
+// page does not submit the form again. code is a key of dashboardMessages.
+func redirectToDashboard(w http.ResponseWriter, r *http.Request, code string, n int) {
+	q := url.Values{"msg": {code}, "n": {strconv.Itoa(n)}}
+	http.Redirect(w, r, "/?"+q.Encode(), http.StatusSeeOther)
+}
+
+// dashboardMessage returns the notice for the msg and n parameters, or ""
+// for unknown codes.
+func dashboardMessage(q url.Values) string {
+	format, ok := dashboardMessages[q.Get("msg")]
+	if !ok {
+		return ""
+	}
+	if !strings.Contains(format, "%d") {
+		return format
+	}
+	n, err := strconv.Atoi(q.Get("n"))
+	if err != nil || n < 0 {
+		return ""
+	}
+	return fmt.Sprintf(format, n)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestDashboardMessage(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", ""},
+		{"msg=synced&n=3", "Sync finished, 3 albums mapped"},
+		{"msg=purged&n=0", "Purged 0 cache entries"},
+		{"msg=purgeEmpty", "Enter an album or asset ID to purge"},
+		{"msg=purged", ""},
+		{"msg=purged&n=-1", ""},
+		{"msg=purged&n=<b>", ""},
+		{"msg=Your+session+expired,+log+in+at+evil.example", ""},
+	}
+	for _, tt := range tests {
+		q, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := dashboardMessage(q); got != tt.want {
+			t.Errorf("dashboardMessage(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	envPrefix         = "IMMICH_PROXY_"
+	configPathEnv     = envPrefix + "CONFIG"
+	defaultConfigPath = "config.yaml"
+)
+
+// configField is a leaf of Config as seen by walkConfig.
+type configField struct {
+	Path  []string // yaml names from the root, e.g. [immich url]
+	Field reflect.StructField
+	Value reflect.Value
+}
+
+// YAMLPath returns the dotted YAML path of the field, e.g. immich.url.
+func (f configField) YAMLPath() string {
+	return strings.Join(f.Path, ".")
+}
+
+// EnvName returns the environment variable overriding the field, e.g. IMMICH_PROXY_IMMICH_URL.
+func (f configField) EnvName() string {
+	parts := make([]string, len(f.Path))
+	for i, p := range f.Path {
+		parts[i] = toEnvSegment(p)
+	}
+	return envPrefix + strings.Join(parts, "_")
+}
+
+// Secret reports whether the field is tagged secret:"true".
+func (f configField) Secret() bool {
+	return f.Field.Tag.Get("secret") == "true"
+}
+
+// walkConfig calls fn for every scalar and []string field reachable from v,
+// which must be an addressable struct value.
+func walkConfig(v reflect.Value, path []string, fn func(configField) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+		fv := v.Field(i)
+		fieldPath := append(append([]string{}, path...), name)
+		if fv.Kind() == reflect.Struct {
+			if err := walkConfig(fv, fieldPath, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(configField{Path: fieldPath, Field: sf, Value: fv}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toEnvSegment converts a yaml name such as albumsSyncEnabled or api_keys
+// to ALBUMS_SYNC_ENABLED or API_KEYS.
+func toEnvSegment(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// applyEnvOverrides overlays IMMICH_PROXY_* variables on cfg. Lists of
+// strings are comma-separated, lists of objects are JSON arrays.
+func applyEnvOverrides(cfg *Config, lookup func(string) (string, bool)) error {
+	return walkConfig(reflect.ValueOf(cfg).Elem(), nil, func(f configField) error {
+		raw, ok := lookup(f.EnvName())
+		if !ok {
+			return nil
+		}
+		if err := setFromString(f.Value, raw); err != nil {
+			return fmt.Errorf("%s: %w", f.EnvName(), err)
+		}
+		return nil
+	})
+}
+
+func setFromString(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String {
+			v.Set(reflect.ValueOf(splitList(raw)))
+			return nil
+		}
+		// lists of objects take a JSON (or flow-style YAML) array
+		ptr := reflect.New(v.Type())
+		if err := yaml.Unmarshal([]byte(raw), ptr.Interface()); err != nil {
+			return fmt.Errorf("invalid %s list: %w", v.Type().Elem().Name(), err)
+		}
+		v.Set(ptr.Elem())
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// configPath returns the config file to load: $IMMICH_PROXY_CONFIG or config.yaml.
+func configPath() string {
+	if p := os.Getenv(configPathEnv); p != "" {
+		return p
+	}
+	return defaultConfigPath
+}
+
+// Masked returns a copy of c with every secret field replaced by its fingerprint.
+func (c *Config) Masked() *Config {
+	masked := *c
+	_ = walkConfig(reflect.ValueOf(&masked).Elem(), nil, func(f configField) error {
+		if f.Value.Kind() == reflect.Slice && f.Value.Type().Elem().Kind() == reflect.Struct {
+			maskItems(f.Value)
+			return nil
+		}
+		if !f.Secret() {
+			return nil
+		}
+		switch f.Value.Kind() {
+		case reflect.String:
+			if f.Value.String() != "" {
+				f.Value.SetString(redactKey(f.Value.String()))
+			}
+		case reflect.Slice:
+			// assign a fresh slice so the original config is untouched
+			items := make([]string, f.Value.Len())
+			for i := range items {
+				items[i] = redactKey(f.Value.Index(i).String())
+			}
+			f.Value.Set(reflect.ValueOf(items))
+		}
+		return nil
+	})
+	return &masked
+}
+
+// maskItems replaces the secret string fields of each struct in the slice v
+// with their fingerprint, in a fresh copy of the slice.
+func maskItems(v reflect.Value) {
+	if v.Len() == 0 {
+		return
+	}
+	items := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+	reflect.Copy(items, v)
+	t := v.Type().Elem()
+	for i := 0; i < items.Len(); i++ {
+		for j := 0; j < t.NumField(); j++ {
+			field := items.Index(i).Field(j)
+			if t.Field(j).Tag.Get("secret") == "true" && field.Kind() == reflect.String && field.String() != "" {
+				field.SetString(redactKey(field.String()))
+			}
+		}
+	}
+	v.Set(items)
+}
+
+// runConfigCommand implements `immich-proxy config print|env`.
+func runConfigCommand(opts *globalOptions, cmd string, stdout, stderr io.Writer) int {
+	switch cmd {
+	case "print":
+		cfg, err := opts.loadConfig()
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to load config: %v\n", err)
+			return 1
+		}
+		if err := yaml.NewEncoder(stdout).Encode(cfg.Masked()); err != nil {
+			fmt.Fprintf(stderr, "failed to print config: %v\n", err)
+			return 1
+		}
+	case "env":
+		_ = walkConfig(reflect.ValueOf(&Config{}).Elem(), nil, func(f configField) error {
+			fmt.Fprintf(stdout, "%-50s %s\n", f.EnvName(), f.YAMLPath())
+			return nil
+		})
+	default:
+		fmt.Fprintf(stderr, "unknown config command %q\n", cmd)
+		return 2
+	}
+	return 0
+}
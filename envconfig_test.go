@@ -0,0 +1,97 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestToEnvSegment(t *testing.T) {
+	tests := map[string]string{
+		"url":                   "URL",
+		"api_keys":              "API_KEYS",
+		"albumsSyncEnabled":     "ALBUMS_SYNC_ENABLED",
+		"publicURL":             "PUBLIC_URL",
+		"clientCAFile":          "CLIENT_CA_FILE",
+		"maxSizeMB":             "MAX_SIZE_MB",
+		"albumsRefreshInterval": "ALBUMS_REFRESH_INTERVAL",
+	}
+	for name, want := range tests {
+		if got := toEnvSegment(name); got != want {
+			t.Errorf("toEnvSegment(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	env := map[string]string{
+		"IMMICH_PROXY_IMMICH_URL":                 "http://immich:2283",
+		"IMMICH_PROXY_IMMICH_API_KEYS":            "k1, k2,",
+		"IMMICH_PROXY_IMMICH_ALBUMS_SYNC_ENABLED": "true",
+		"IMMICH_PROXY_IMMICH_LOGINS":              `[{"email": "a@example.com", "password": "pw"}]`,
+		"IMMICH_PROXY_AUDIT_MAX_SIZE_MB":          "20",
+		"IMMICH_PROXY_TRACING_SAMPLE_RATIO":       "0.5",
+		"IMMICH_PROXY_ALBUM_RULES":                `[{"deny": ["private*"]}]`,
+	}
+	cfg := &Config{Listen: ":9000"}
+	cfg.Immich.URL = "http://from-file"
+	err := applyEnvOverrides(cfg, func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Immich.URL != "http://immich:2283" {
+		t.Errorf("immich.url = %q", cfg.Immich.URL)
+	}
+	if !slices.Equal(cfg.Immich.APIKeys, []string{"k1", "k2"}) {
+		t.Errorf("immich.api_keys = %q", cfg.Immich.APIKeys)
+	}
+	if !cfg.Immich.AlbumsSyncEnabled || cfg.Audit.MaxSizeMB != 20 || cfg.Tracing.SampleRatio != 0.5 {
+		t.Errorf("scalars not overridden: %v %d %v", cfg.Immich.AlbumsSyncEnabled, cfg.Audit.MaxSizeMB, cfg.Tracing.SampleRatio)
+	}
+	if len(cfg.Immich.Logins) != 1 || cfg.Immich.Logins[0] != (LoginConfig{Email: "a@example.com", Password: "pw"}) {
+		t.Errorf("immich.logins = %+v", cfg.Immich.Logins)
+	}
+	if len(cfg.AlbumRules) != 1 || !slices.Equal(cfg.AlbumRules[0].Deny, []string{"private*"}) {
+		t.Errorf("albumRules = %+v", cfg.AlbumRules)
+	}
+	if cfg.Listen != ":9000" {
+		t.Errorf("listen without a variable = %q, want it kept", cfg.Listen)
+	}
+}
+
+func TestApplyEnvOverridesInvalid(t *testing.T) {
+	for name, value := range map[string]string{
+		"IMMICH_PROXY_IMMICH_ALBUMS_SYNC_ENABLED": "maybe",
+		"IMMICH_PROXY_AUDIT_MAX_SIZE_MB":          "20MB",
+		"IMMICH_PROXY_IMMICH_LOGINS":              `[{"email": `,
+	} {
+		err := applyEnvOverrides(&Config{}, func(n string) (string, bool) { return value, n == name })
+		if err == nil {
+			t.Errorf("%s=%s: no error", name, value)
+		}
+	}
+}
+
+func TestMasked(t *testing.T) {
+	cfg := &Config{}
+	cfg.Immich.URL = "http://immich:2283"
+	cfg.Immich.APIKeys = []string{"secret-key"}
+	cfg.Immich.Logins = []LoginConfig{{Email: "a@example.com", Password: "pw"}}
+	cfg.Admin.Token = "admin-token-1234567"
+
+	masked := cfg.Masked()
+	if masked.Immich.URL != cfg.Immich.URL {
+		t.Errorf("immich.url masked: %q", masked.Immich.URL)
+	}
+	if masked.Immich.APIKeys[0] != redactKey("secret-key") || masked.Admin.Token != redactKey("admin-token-1234567") {
+		t.Errorf("secrets not masked: %q %q", masked.Immich.APIKeys, masked.Admin.Token)
+	}
+	if masked.Immich.Logins[0].Password != redactKey("pw") || masked.Immich.Logins[0].Email != "a@example.com" {
+		t.Errorf("immich.logins = %+v", masked.Immich.Logins)
+	}
+	if cfg.Immich.APIKeys[0] != "secret-key" || cfg.Immich.Logins[0].Password != "pw" {
+		t.Error("Masked changed the original config")
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	feedPageSize = 50
+	feedMaxAge   = 5 * time.Minute
+	mediaRSSNS   = "http://search.yahoo.com/mrss/"
+)
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	MediaNS  string      `xml:"xmlns:media,attr"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Author   atomAuthor  `xml:"author"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published,omitempty"`
+	Links     []atomLink `xml:"link"`
+	Summary   string     `xml:"summary,omitempty"`
+	Content   atomText   `xml:"content"`
+	// Media RSS, prefixed by hand as encoding/xml cannot choose prefixes
+	Thumbnail        mediaThumbnail `xml:"media:thumbnail"`
+	MediaContent     mediaContent   `xml:"media:content"`
+	MediaDescription string         `xml:"media:description,omitempty"`
+}
+
+type mediaThumbnail struct {
+	URL string `xml:"url,attr"`
+}
+
+type mediaContent struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Medium string `xml:"medium,attr"`
+}
+
+// FeedHandler processes requests to /feeds/albums/{id}.atom?key=&page=
+func (s *ImmichService) FeedHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+	albumID := mux.Vars(r)["id"]
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid page", http.StatusBadRequest)
+			return
+		}
+		page = n
+	}
+	shared, ok := s.sharedAlbumAccess(w, r, albumID)
+	if !ok {
+		return
+	}
+
+	album, err := s.client.GetAlbumInfo(r.Context(), albumID, false)
+	if errors.Is(err, ErrAlbumNotFound) {
+		http.Error(w, "Album not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to get album info: %v", err)
+		http.Error(w, "Failed to get album info", http.StatusInternalServerError)
+		return
+	}
+	assets := slices.DeleteFunc(album.Assets, func(a AssetInfo) bool { return a.IsTrashed })
+	slices.SortStableFunc(assets, func(x, y AssetInfo) int { return y.TakenAt().Compare(x.TakenAt()) })
+	pages := max(1, (len(assets)+feedPageSize-1)/feedPageSize)
+	if page > pages {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	assets = assets[(page-1)*feedPageSize : min(page*feedPageSize, len(assets))]
+
+	links := newAssetLinks(s.baseURL(r), GetShareKey(r))
+	feed := atomFeed{
+		MediaNS:  mediaRSSNS,
+		ID:       "urn:immich-proxy:album:" + album.ID,
+		Title:    album.AlbumName,
+		Subtitle: album.Description,
+		Updated:  atomTime(latest(album.UpdatedAt, album.LastModifiedAssetTimestamp)),
+		Author:   atomAuthor{Name: album.AlbumName},
+		Links:    []atomLink{{Rel: "self", Href: links.feed(albumID, page), Type: "application/atom+xml"}},
+	}
+	feed.Links = append(feed.Links,
+		atomLink{Rel: "first", Href: links.feed(albumID, 1)},
+		atomLink{Rel: "last", Href: links.feed(albumID, pages)})
+	if page > 1 {
+		feed.Links = append(feed.Links, atomLink{Rel: "previous", Href: links.feed(albumID, page-1)})
+	}
+	if page < pages {
+		feed.Links = append(feed.Links, atomLink{Rel: "next", Href: links.feed(albumID, page+1)})
+	}
+	for _, asset := range assets {
+		feed.Entries = append(feed.Entries, feedEntry(asset, links, shared))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(feed); err != nil {
+		logger.Errorf("Failed to encode feed: %v", err)
+		http.Error(w, "Failed to encode feed", http.StatusInternalServerError)
+		return
+	}
+	serveWithETag(w, r, "application/atom+xml; charset=utf-8", buf.Bytes(), feedMaxAge)
+}
+
+func feedEntry(asset AssetInfo, links assetLinks, shared sharedAlbum) atomEntry {
+	preview := links.asset(asset.ID, "thumbnail", "preview")
+	label := assetLabel(asset, shared) // file names are metadata as well
+	e := atomEntry{
+		ID:           "urn:immich-proxy:asset:" + asset.ID,
+		Title:        label,
+		Updated:      atomTime(latest(asset.UpdatedAt, asset.FileCreatedAt)),
+		Links:        []atomLink{{Rel: "alternate", Href: preview, Type: "image/jpeg"}},
+		Thumbnail:    mediaThumbnail{URL: links.asset(asset.ID, "thumbnail", "thumbnail")},
+		MediaContent: mediaContent{URL: preview, Type: "image/jpeg", Medium: "image"},
+	}
+	if shared.AllowDownload {
+		e.Links = append(e.Links, atomLink{Rel: "enclosure", Href: links.asset(asset.ID, "original", "")})
+	}
+	content := fmt.Sprintf(`<img src="%s" alt="%s">`, html.EscapeString(preview), html.EscapeString(label))
+	// capture date and description are EXIF, hidden unless the link shows metadata
+	if shared.ShowMetadata {
+		if t := asset.TakenAt(); !t.IsZero() {
+			e.Published = t.UTC().Format(time.RFC3339)
+		}
+		if asset.ExifInfo != nil && asset.ExifInfo.Description != nil && *asset.ExifInfo.Description != "" {
+			e.Summary = *asset.ExifInfo.Description
+			e.MediaDescription = e.Summary
+			content += "<p>" + html.EscapeString(e.Summary) + "</p>"
+		}
+	}
+	e.Content = atomText{Type: "html", Body: content}
+	return e
+}
+
+// assetLinks builds absolute proxy URLs carrying the share key.
+type assetLinks struct {
+	base string
+	key  string
+}
+
+func newAssetLinks(base, key string) assetLinks {
+	return assetLinks{base: base, key: url.QueryEscape(key)}
+}
+
+// asset returns the URL of an asset endpoint: "" for the asset info,
+// "thumbnail" with a size, or "original".
+func (l assetLinks) asset(assetID, endpoint, size string) string {
+	u := l.base + "/api/assets/" + url.PathEscape(assetID)
+	if endpoint != "" {
+		u += "/" + endpoint
+	}
+	u += "?key=" + l.key
+	if size != "" {
+		u += "&size=" + size
+	}
+	return u
+}
+
+func (l assetLinks) feed(albumID string, page int) string {
+	u := fmt.Sprintf("%s/feeds/albums/%s.atom?key=%s", l.base, url.PathEscape(albumID), l.key)
+	if page > 1 {
+		u += "&page=" + strconv.Itoa(page)
+	}
+	return u
+}
+
+// baseURL returns the configured public URL of the proxy, or the one the
+// request was sent to.
+func (s *ImmichService) baseURL(r *http.Request) string {
+	if s.publicURL != "" {
+		return s.publicURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// latest returns the later of two RFC 3339 times; unparsable ones lose.
+func latest(a, b string) time.Time {
+	ta, _ := time.Parse(time.RFC3339Nano, a)
+	tb, _ := time.Parse(time.RFC3339Nano, b)
+	if tb.After(ta) {
+		return tb
+	}
+	return ta
+}
+
+func atomTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// serveWithETag writes body with a strong ETag of its content, or only 304
+// Not Modified when the client already holds it.
+func serveWithETag(w http.ResponseWriter, r *http.Request, contentType string, body []byte, maxAge time.Duration) {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:12]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(maxAge.Seconds())))
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if _, err := w.Write(body); err != nil {
+		requestLogger(r.Context()).Debugf("Failed to write response: %v", err)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header holds etag, compared
+// weakly as RFC 9110 asks for.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEtagMatches(t *testing.T) {
+	const etag = `"abc"`
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`"xyz",W/"abc"`, true},
+		{`"xyz"`, false},
+		{`abc`, false},
+		{`*`, true},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, etag); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestServeWithETag(t *testing.T) {
+	serve := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/feeds/albums/a1.atom", nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		serveWithETag(w, r, "text/plain", []byte("body"), feedMaxAge)
+		return w
+	}
+	first := serve("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || first.Body.String() != "body" || etag == "" {
+		t.Fatalf("got %d %q with ETag %q, want 200 with the body and an ETag", first.Code, first.Body, etag)
+	}
+	if again := serve(etag); again.Code != http.StatusNotModified || again.Body.Len() != 0 {
+		t.Errorf("revalidation got %d with %d bytes, want an empty 304", again.Code, again.Body.Len())
+	}
+	if other := serve(`"other"`); other.Code != http.StatusOK {
+		t.Errorf("stale ETag got %d, want 200", other.Code)
+	}
+}
+
+func TestFeedEntryHidesMetadata(t *testing.T) {
+	description := "Beach"
+	asset := AssetInfo{
+		ID:               "as1",
+		Type:             "IMAGE",
+		OriginalFileName: "IMG_0042 at home.jpg",
+		ExifInfo:         &ExifInfo{Description: &description},
+	}
+	links := newAssetLinks("https://photos.example.com", "sk1")
+	tests := []struct {
+		name   string
+		shared sharedAlbum
+		title  string
+		hidden []string
+	}{
+		{name: "without metadata", shared: sharedAlbum{}, title: "Photo", hidden: []string{"IMG_0042", "Beach", "/original"}},
+		{name: "with metadata", shared: sharedAlbum{ShowMetadata: true, AllowDownload: true}, title: "IMG_0042 at home.jpg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := feedEntry(asset, links, tt.shared)
+			if e.Title != tt.title {
+				t.Errorf("title = %q, want %q", e.Title, tt.title)
+			}
+			all := e.Title + e.Summary + e.Content.Body
+			for _, l := range e.Links {
+				all += l.Href
+			}
+			for _, s := range tt.hidden {
+				if strings.Contains(all, s) {
+					t.Errorf("entry contains %q: %+v", s, e)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultFrameInterval = 30 * time.Second
+	minFrameInterval     = 5 * time.Second
+	frameRefresh         = time.Minute // how often a frame looks for new assets
+)
+
+var frameTemplate = parseTemplate("frame.html")
+
+// FrameAsset is one slide of a photo frame.
+type FrameAsset struct {
+	ID      string `json:"id"`
+	URL     string `json:"url"` // preview through the proxy
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+	TakenAt string `json:"takenAt,omitempty"`
+	// Location is city and country, from EXIF like TakenAt
+	Location string `json:"location,omitempty"`
+}
+
+// FrameResponse is the JSON a photo frame polls for its slides.
+type FrameResponse struct {
+	AlbumID   string       `json:"albumId"`
+	AlbumName string       `json:"albumName"`
+	Stale     bool         `json:"stale"` // served from the last snapshot, Immich is unreachable
+	Assets    []FrameAsset `json:"assets"`
+}
+
+// frameConfig is handed to the script of the frame page.
+type frameConfig struct {
+	API      string `json:"api"`
+	Interval int64  `json:"interval"` // ms
+	Refresh  int64  `json:"refresh"`  // ms
+	Shuffle  bool   `json:"shuffle"`
+	Captions bool   `json:"captions"`
+}
+
+type frameData struct {
+	Title  string
+	Config frameConfig
+}
+
+// FrameHandler processes requests to /frame/{id}?key=&interval=&shuffle=&orientation=&captions=
+func (s *ImmichService) FrameHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+	albumID := mux.Vars(r)["id"]
+	q := r.URL.Query()
+	orientation, ok := parseOrientation(w, q.Get("orientation"))
+	if !ok {
+		return
+	}
+	interval := defaultFrameInterval
+	if v := q.Get("interval"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || time.Duration(seconds)*time.Second < minFrameInterval {
+			http.Error(w, "Invalid interval, must be at least 5 seconds", http.StatusBadRequest)
+			return
+		}
+		interval = time.Duration(seconds) * time.Second
+	}
+	shuffle, captions := false, true
+	for name, flag := range map[string]*bool{"shuffle": &shuffle, "captions": &captions} {
+		if v := q.Get(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "Invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*flag = b
+		}
+	}
+	shared, ok := s.sharedAlbumAccess(w, r, albumID)
+	if !ok {
+		return
+	}
+
+	api := url.Values{"key": {GetShareKey(r)}}
+	if orientation != "" {
+		api.Set("orientation", orientation)
+	}
+	data := frameData{
+		Title: shared.AlbumName,
+		Config: frameConfig{
+			API:      s.publicURL + "/api/albums/" + url.PathEscape(albumID) + "/frame?" + api.Encode(),
+			Interval: interval.Milliseconds(),
+			Refresh:  frameRefresh.Milliseconds(),
+			Shuffle:  shuffle,
+			Captions: captions && shared.ShowMetadata,
+		},
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := frameTemplate.Execute(w, data); err != nil {
+		logger.Errorf("Failed to render frame: %v", err)
+	}
+}
+
+// FrameAssetsHandler processes requests to /api/albums/{id}/frame?key=&orientation=
+func (s *ImmichService) FrameAssetsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+	albumID := mux.Vars(r)["id"]
+	orientation, ok := parseOrientation(w, r.URL.Query().Get("orientation"))
+	if !ok {
+		return
+	}
+	shared, ok := s.sharedAlbumAccess(w, r, albumID)
+	if !ok {
+		return
+	}
+
+	album, stale, err := s.albumAssets(r.Context(), albumID)
+	if errors.Is(err, ErrAlbumNotFound) {
+		http.Error(w, "Album not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to get album info: %v", err)
+		http.Error(w, "Failed to get album info", http.StatusInternalServerError)
+		return
+	}
+	assets := slices.DeleteFunc(album.Assets, func(a AssetInfo) bool {
+		return a.IsTrashed || a.Type != "IMAGE" || (orientation != "" && assetOrientation(a) != orientation)
+	})
+	slices.SortStableFunc(assets, func(x, y AssetInfo) int { return x.TakenAt().Compare(y.TakenAt()) })
+
+	links := newAssetLinks(s.publicURL, GetShareKey(r))
+	resp := FrameResponse{AlbumID: album.ID, AlbumName: album.AlbumName, Stale: stale, Assets: []FrameAsset{}}
+	for _, asset := range assets {
+		resp.Assets = append(resp.Assets, newFrameAsset(asset, links, shared))
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		logger.Errorf("Failed to encode frame: %v", err)
+		http.Error(w, "Failed to encode frame", http.StatusInternalServerError)
+		return
+	}
+	// frames poll, so have them revalidate every time; unchanged lists cost a 304
+	serveWithETag(w, r, "application/json", body, 0)
+}
+
+func newFrameAsset(asset AssetInfo, links assetLinks, shared sharedAlbum) FrameAsset {
+	slide := FrameAsset{ID: asset.ID, URL: links.asset(asset.ID, "thumbnail", "preview")}
+	slide.Width, slide.Height, _ = asset.Dimensions()
+	// capture date and place are EXIF, hidden unless the link shows metadata
+	if shared.ShowMetadata {
+		if t := asset.TakenAt(); !t.IsZero() {
+			slide.TakenAt = t.Format(time.RFC3339)
+		}
+		slide.Location = assetLocation(asset)
+	}
+	return slide
+}
+
+// parseOrientation checks an orientation filter, answering the request
+// with an error if it is invalid.
+func parseOrientation(w http.ResponseWriter, orientation string) (string, bool) {
+	switch orientation {
+	case "", "landscape", "portrait", "square":
+		return orientation, true
+	}
+	http.Error(w, "Invalid orientation, must be landscape, portrait or square", http.StatusBadRequest)
+	return "", false
+}
+
+// assetOrientation returns landscape, portrait or square, or "" when the
+// size of the asset is unknown.
+func assetOrientation(a AssetInfo) string {
+	width, height, ok := a.Dimensions()
+	switch {
+	case !ok:
+		return ""
+	case width > height:
+		return "landscape"
+	case width < height:
+		return "portrait"
+	}
+	return "square"
+}
+
+// assetLocation returns where the asset was taken as "City, Country".
+func assetLocation(a AssetInfo) string {
+	if a.ExifInfo == nil {
+		return ""
+	}
+	var parts []string
+	for _, p := range []*string{a.ExifInfo.City, a.ExifInfo.Country} {
+		if p != nil && *p != "" {
+			parts = append(parts, *p)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
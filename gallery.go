@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	galleryPageSize  = 100
+	galleryMaxAge    = time.Minute
+	galleryRowHeight = 180 // px, rows are stretched from there to fill the width
+)
+
+var galleryTemplate = parseTemplate("gallery.html")
+
+type galleryData struct {
+	Title       string
+	Description string
+	Count       int
+	Stale       bool
+	Items       []galleryItem
+	Page, Pages int
+	PrevPage    string
+	NextPage    string
+}
+
+// galleryItem is a cell of the grid and its lightbox.
+type galleryItem struct {
+	ID       string
+	Label    string // file name, or Photo or Video unless the link shows metadata
+	Download string // name the original is saved under
+	Video    bool
+	Thumb    string
+	Preview  string
+	Original string // empty unless the link allows downloads
+	Caption  string
+	// Grow and Basis size the cell after its aspect ratio, Ratio is its
+	// height in percent of its width
+	Grow, Basis int
+	Ratio       float64
+	Prev, Next  string // IDs of the neighbours in the lightbox, which videos are not in
+}
+
+// GalleryHandler processes requests to /g/{id}?key=&page=
+func (s *ImmichService) GalleryHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+	albumID := mux.Vars(r)["id"]
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid page", http.StatusBadRequest)
+			return
+		}
+		page = n
+	}
+	shared, ok := s.sharedAlbumAccess(w, r, albumID)
+	if !ok {
+		return
+	}
+
+	album, stale, err := s.albumAssets(r.Context(), albumID)
+	if errors.Is(err, ErrAlbumNotFound) {
+		http.Error(w, "Album not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to get album info: %v", err)
+		http.Error(w, "Failed to get album info", http.StatusInternalServerError)
+		return
+	}
+	assets := slices.DeleteFunc(album.Assets, func(a AssetInfo) bool { return a.IsTrashed })
+	// same order as in Immich, newest first unless the album says otherwise
+	slices.SortStableFunc(assets, func(x, y AssetInfo) int {
+		if album.Order == "asc" {
+			return x.TakenAt().Compare(y.TakenAt())
+		}
+		return y.TakenAt().Compare(x.TakenAt())
+	})
+	pages := max(1, (len(assets)+galleryPageSize-1)/galleryPageSize)
+	if page > pages {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	count := len(assets)
+	assets = assets[(page-1)*galleryPageSize : min(page*galleryPageSize, len(assets))]
+
+	shareKey := GetShareKey(r)
+	links := newAssetLinks(s.publicURL, shareKey)
+	data := galleryData{
+		Title:       album.AlbumName,
+		Description: album.Description,
+		Count:       count,
+		Stale:       stale,
+		Page:        page,
+		Pages:       pages,
+	}
+	if page > 1 {
+		data.PrevPage = galleryPageURL(s.publicURL, albumID, shareKey, page-1)
+	}
+	if page < pages {
+		data.NextPage = galleryPageURL(s.publicURL, albumID, shareKey, page+1)
+	}
+	// videos have no lightbox: the proxy only serves their original, which
+	// browsers cannot stream, so previous and next skip over them
+	prev := -1
+	for _, asset := range assets {
+		item := newGalleryItem(asset, links, shared)
+		if !item.Video {
+			if prev >= 0 {
+				item.Prev = data.Items[prev].ID
+				data.Items[prev].Next = item.ID
+			}
+			prev = len(data.Items)
+		}
+		data.Items = append(data.Items, item)
+	}
+
+	var buf bytes.Buffer
+	if err := galleryTemplate.Execute(&buf, data); err != nil {
+		logger.Errorf("Failed to render gallery: %v", err)
+		http.Error(w, "Failed to render gallery", http.StatusInternalServerError)
+		return
+	}
+	serveWithETag(w, r, "text/html; charset=utf-8", buf.Bytes(), galleryMaxAge)
+}
+
+func newGalleryItem(asset AssetInfo, links assetLinks, shared sharedAlbum) galleryItem {
+	item := galleryItem{
+		ID:      asset.ID,
+		Label:   assetLabel(asset, shared),
+		Video:   asset.Type == "VIDEO",
+		Thumb:   links.asset(asset.ID, "thumbnail", "thumbnail"),
+		Preview: links.asset(asset.ID, "thumbnail", "preview"),
+	}
+	if shared.AllowDownload {
+		item.Original = links.asset(asset.ID, "original", "")
+		item.Download = asset.ID + path.Ext(asset.OriginalFileName)
+		if shared.ShowMetadata {
+			item.Download = asset.OriginalFileName
+		}
+	}
+	aspect := 1.0
+	if width, height, ok := asset.Dimensions(); ok {
+		aspect = float64(width) / float64(height)
+	}
+	item.Grow = int(aspect * 100)
+	item.Basis = int(aspect * galleryRowHeight)
+	item.Ratio = float64(int(1000/aspect)) / 10
+	// capture date, place and description are EXIF, hidden unless the link shows metadata
+	if shared.ShowMetadata {
+		var caption []string
+		if t := asset.TakenAt(); !t.IsZero() {
+			caption = append(caption, t.Format("2 January 2006"))
+		}
+		if location := assetLocation(asset); location != "" {
+			caption = append(caption, location)
+		}
+		if asset.ExifInfo != nil && asset.ExifInfo.Description != nil && *asset.ExifInfo.Description != "" {
+			caption = append(caption, *asset.ExifInfo.Description)
+		}
+		item.Caption = strings.Join(caption, " · ")
+	}
+	return item
+}
+
+// assetLabel names an asset for people: its file name when the link shows
+// metadata, which file names often are, or else only what kind it is.
+func assetLabel(asset AssetInfo, shared sharedAlbum) string {
+	switch {
+	case shared.ShowMetadata && asset.OriginalFileName != "":
+		return asset.OriginalFileName
+	case asset.Type == "VIDEO":
+		return "Video"
+	}
+	return "Photo"
+}
+
+func galleryPageURL(base, albumID, shareKey string, page int) string {
+	q := url.Values{"key": {shareKey}}
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	return base + "/g/" + url.PathEscape(albumID) + "?" + q.Encode()
+}
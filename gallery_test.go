@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newGalleryTestServer serves a proxy in front of a fake Immich holding
+// album a1, shared with key sk1, with assets as0 (newest) to as149. Every
+// tenth asset is a video; file names are name0.jpg and so on.
+func newGalleryTestServer(t *testing.T, showMetadata bool) *httptest.Server {
+	t.Helper()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var assets []AssetInfo
+	for i := 149; i >= 0; i-- {
+		a := AssetInfo{
+			ID:               fmt.Sprintf("as%d", i),
+			Type:             "IMAGE",
+			OriginalFileName: fmt.Sprintf("name%d.jpg", i),
+			FileCreatedAt:    start.Add(time.Duration(-i) * time.Hour).Format(time.RFC3339),
+		}
+		if i%10 == 5 {
+			a.Type = "VIDEO"
+		}
+		assets = append(assets, a)
+	}
+	album := AlbumInfo{ID: "a1", AlbumName: "Trip", AssetCount: len(assets), Assets: assets}
+
+	immich := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp any
+		switch r.URL.Path {
+		case "/api/shared-links/me":
+			if r.URL.Query().Get("key") != "sk1" {
+				http.Error(w, `{"message":"Invalid share key"}`, http.StatusUnauthorized)
+				return
+			}
+			resp = map[string]any{"key": "sk1", "type": "ALBUM", "album": album, "allowDownload": true, "showMetadata": showMetadata}
+		case "/api/albums":
+			resp = []AlbumInfo{{ID: album.ID, AlbumName: album.AlbumName, AssetCount: album.AssetCount}}
+		case "/api/albums/a1":
+			resp = album
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(immich.Close)
+
+	albumsKeys := NewAlbumsKeys([]string{"key"}, false, immich.URL)
+	service := NewImmichService(NewIMMICHClient(immich.URL, albumsKeys))
+	var cors atomic.Pointer[CORSConfig]
+	cors.Store(&CORSConfig{})
+	proxy := httptest.NewServer(NewRouter(service, &cors, nil, nil))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+func getGallery(t *testing.T, proxy *httptest.Server, query string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(proxy.URL + "/g/a1?" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestGalleryPagination(t *testing.T) {
+	proxy := newGalleryTestServer(t, false)
+	tests := []struct {
+		query    string
+		status   int
+		cells    int
+		contains []string
+		absent   []string
+	}{
+		{
+			query: "key=sk1", status: http.StatusOK, cells: 100,
+			contains: []string{"150 photos and videos, page 1 of 2", `id="g-as0"`, `id="g-as99"`, "page=2"},
+			absent:   []string{`id="g-as100"`, "Previous page"},
+		},
+		{
+			query: "key=sk1&page=2", status: http.StatusOK, cells: 50,
+			contains: []string{"page 2 of 2", `id="g-as100"`, `id="g-as149"`, "Previous page"},
+			absent:   []string{`id="g-as99"`, "Next page"},
+		},
+		{query: "key=sk1&page=3", status: http.StatusNotFound},
+		{query: "key=sk1&page=0", status: http.StatusBadRequest},
+		{query: "key=sk1&page=x", status: http.StatusBadRequest},
+		{query: "key=other", status: http.StatusNotFound},
+		{query: "", status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			status, body := getGallery(t, proxy, tt.query)
+			if status != tt.status {
+				t.Fatalf("status %d, want %d: %s", status, tt.status, body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if n := strings.Count(body, `class="cell"`); n != tt.cells {
+				t.Errorf("%d cells, want %d", n, tt.cells)
+			}
+			for _, s := range tt.contains {
+				if !strings.Contains(body, s) {
+					t.Errorf("page lacks %q", s)
+				}
+			}
+			for _, s := range tt.absent {
+				if strings.Contains(body, s) {
+					t.Errorf("page contains %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestGalleryLightboxSkipsVideos(t *testing.T) {
+	_, body := getGallery(t, newGalleryTestServer(t, false), "key=sk1")
+	if strings.Contains(body, `id="a-as5"`) {
+		t.Error("video as5 has a lightbox")
+	}
+	if !strings.Contains(body, `href="/api/assets/as5/original?key=sk1" download="as5.jpg"`) {
+		t.Error("video as5 does not link to its original")
+	}
+	// as4 is followed by as6 in the lightbox, over the video in between
+	lightbox := body[strings.Index(body, `id="a-as4"`):]
+	lightbox = lightbox[:strings.Index(lightbox, `class="lb"`)]
+	if !strings.Contains(lightbox, `href="#a-as6"`) {
+		t.Errorf("lightbox of as4 does not lead to as6:\n%s", lightbox)
+	}
+}
+
+func TestGalleryFileNames(t *testing.T) {
+	for _, showMetadata := range []bool{false, true} {
+		_, body := getGallery(t, newGalleryTestServer(t, showMetadata), "key=sk1")
+		if got := strings.Contains(body, "name1.jpg"); got != showMetadata {
+			t.Errorf("showMetadata %v: page shows file names: %v", showMetadata, got)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	readinessTimeout    = 5 * time.Second
+	keyCheckCacheTTL    = time.Minute
+	checkStatusOK       = "ok"
+	checkStatusFail     = "fail"
+	checkStatusSkip     = "skipped"
+	checkStatusDegraded = "degraded"
+)
+
+// CheckResult is the outcome of a single readiness check.
+type CheckResult struct {
+	Status    string            `json:"status"`
+	Error     string            `json:"error,omitempty"`
+	LatencyMs float64           `json:"latencyMs,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// HealthChecker reports liveness and readiness of the proxy. API key checks
+// hit Immich once per key, so their result is cached for keyCheckCacheTTL.
+type HealthChecker struct {
+	client *IMMICHClient
+
+	lock        sync.Mutex // to protect keysResult and keysChecked
+	keysResult  CheckResult
+	keysChecked time.Time
+}
+
+func NewHealthChecker(client *IMMICHClient) *HealthChecker {
+	return &HealthChecker{client: client}
+}
+
+// HealthzHandler processes requests to /healthz. It only reports that the process is serving.
+func (h *HealthChecker) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": checkStatusOK})
+}
+
+// ReadyzHandler processes requests to /readyz. Anyone can reach it, so it
+// only tells the status of each check; upstream errors and key
+// fingerprints are logged and served on the admin listener.
+func (h *HealthChecker) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	resp, status := h.readiness(r.Context())
+	for name, check := range resp.Checks {
+		resp.Checks[name] = CheckResult{Status: check.Status}
+	}
+	writeJSON(w, status, resp)
+}
+
+// AdminReadyzHandler processes requests to /admin/readyz on the admin
+// listener, with the details of each check.
+func (h *HealthChecker) AdminReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	resp, status := h.readiness(r.Context())
+	writeJSON(w, status, resp)
+}
+
+func (h *HealthChecker) readiness(ctx context.Context) (ReadinessResponse, int) {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	resp := ReadinessResponse{
+		Status: "ready",
+		Checks: map[string]CheckResult{
+			"immich":     h.checkImmich(ctx),
+			"apiKeys":    h.checkAPIKeys(ctx),
+			"albumsSync": h.checkAlbumsSync(),
+		},
+	}
+	status := http.StatusOK
+	for name, check := range resp.Checks {
+		if check.Status == checkStatusFail {
+			log.Debugf("Readiness check %s failed: %s", name, check.Error)
+			resp.Status = "not ready"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	return resp, status
+}
+
+func (h *HealthChecker) checkImmich(ctx context.Context) CheckResult {
+	start := time.Now()
+	err := h.client.Ping(ctx)
+	res := CheckResult{Status: checkStatusOK, LatencyMs: msSince(start)}
+	if err != nil {
+		res.Status = checkStatusFail
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// checkAPIKeys fails only when no key is valid; some invalid keys, or keys
+// lacking permissions, degrade readiness.
+func (h *HealthChecker) checkAPIKeys(ctx context.Context) CheckResult {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	keys := h.client.AlbumsKeys.Keys()
+	// keys the key monitor has checked are not checked again, and while it
+	// covers every key its results are used as they are, without caching
+	health := h.client.AlbumsKeys.KeyHealth()
+	monitored := !slices.ContainsFunc(keys, func(key string) bool {
+		_, ok := health[key]
+		return !ok
+	})
+	if !monitored && !h.keysChecked.IsZero() && time.Since(h.keysChecked) < keyCheckCacheTTL {
+		return h.keysResult
+	}
+
+	res := CheckResult{Status: checkStatusOK, Details: make(map[string]string, len(keys))}
+	if len(keys) == 0 {
+		res.Status = checkStatusFail
+		res.Error = "no API keys configured"
+		return res
+	}
+	valid, lacking := 0, 0
+	for _, key := range keys {
+		kh, ok := health[key]
+		if !ok {
+			// a 403 is a valid key restricted to album and asset permissions,
+			// as for the key monitor
+			if _, err := h.client.GetMyUser(ctx, key); err != nil && !hasStatus(err, http.StatusForbidden) {
+				res.Details[hashKey(key)] = err.Error()
+				continue
+			}
+			kh = KeyHealth{Status: keyHealthOK}
+		}
+		switch {
+		case kh.Status != keyHealthOK:
+			res.Details[hashKey(key)] = kh.Status + ": " + kh.Error
+			continue
+		case len(kh.MissingPermissions) > 0:
+			res.Details[hashKey(key)] = "missing " + strings.Join(kh.MissingPermissions, ", ")
+			lacking++
+		default:
+			res.Details[hashKey(key)] = checkStatusOK
+		}
+		valid++
+	}
+	switch {
+	case valid == 0:
+		res.Status = checkStatusFail
+		res.Error = "no valid API key"
+	case valid < len(keys) || lacking > 0:
+		res.Status = checkStatusDegraded
+	}
+	h.keysResult = res
+	h.keysChecked = time.Now()
+	return res
+}
+
+func (h *HealthChecker) checkAlbumsSync() CheckResult {
+	a := h.client.AlbumsKeys
+	if !a.syncEnabled {
+		return CheckResult{Status: checkStatusSkip}
+	}
+	if !a.HasSynced() {
+		return CheckResult{Status: checkStatusFail, Error: "initial album sync has not completed"}
+	}
+	return CheckResult{Status: checkStatusOK, Details: map[string]string{
+		"lastSync": a.LastSync().UTC().Format(time.RFC3339),
+	}}
+}
+
+func msSince(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("failed to write json response: %v", err)
+	}
+}
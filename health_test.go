@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadyzHidesDetails(t *testing.T) {
+	// an Immich that is down and rejects the only key
+	immich := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/users/me" {
+			http.Error(w, `{"message":"Invalid API key"}`, http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "upstream secret detail", http.StatusBadGateway)
+	}))
+	defer immich.Close()
+	const key = "key-one"
+	health := NewHealthChecker(NewIMMICHClient(immich.URL, NewAlbumsKeys([]string{key}, false, immich.URL)))
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		details bool
+	}{
+		{name: "public", handler: health.ReadyzHandler},
+		{name: "admin", handler: health.AdminReadyzHandler, details: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("status %d, want 503", w.Code)
+			}
+			var resp ReadinessResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"immich", "apiKeys"} {
+				if resp.Checks[name].Status != checkStatusFail {
+					t.Errorf("%s check %q, want fail", name, resp.Checks[name].Status)
+				}
+			}
+			body := w.Body.String()
+			for _, s := range []string{"upstream secret detail", hashKey(key), immich.URL} {
+				if strings.Contains(body, s) != tt.details {
+					t.Errorf("body contains %q: %v, want %v\n%s", s, !tt.details, tt.details, body)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckAPIKeys(t *testing.T) {
+	// /users/me answers by key: keys restricted to album and asset
+	// permissions get a 403, revoked keys a 401
+	usersMe := map[string]int{"good": http.StatusOK, "restricted": http.StatusForbidden, "revoked": http.StatusUnauthorized}
+	immich := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := usersMe[r.Header.Get("x-api-key")]
+		if status != http.StatusOK {
+			http.Error(w, `{"message":"no"}`, status)
+			return
+		}
+		w.Write([]byte(`{"id":"u1","email":"good@example.com"}`))
+	}))
+	defer immich.Close()
+
+	tests := []struct {
+		name string
+		keys []string
+		want string
+	}{
+		{name: "valid", keys: []string{"good"}, want: checkStatusOK},
+		{name: "restricted is valid", keys: []string{"restricted"}, want: checkStatusOK},
+		{name: "one revoked", keys: []string{"restricted", "revoked"}, want: checkStatusDegraded},
+		{name: "all revoked", keys: []string{"revoked"}, want: checkStatusFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := NewHealthChecker(NewIMMICHClient(immich.URL, NewAlbumsKeys(tt.keys, false, immich.URL)))
+			res := health.checkAPIKeys(context.Background())
+			if res.Status != tt.want {
+				t.Errorf("status %q, want %q: %v", res.Status, tt.want, res.Details)
+			}
+		})
+	}
+}
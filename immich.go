@@ -2,12 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -24,7 +26,7 @@ func NewIMMICHClient(immichURL string, albumsKeys *AlbumsKeys) *IMMICHClient {
 	}
 }
 
-func (c *IMMICHClient) request(endpoint, method, apiKey string, body interface{}, out interface{}) error {
+func (c *IMMICHClient) request(ctx context.Context, endpoint, method, apiKey string, body interface{}, out interface{}) error {
 	url := fmt.Sprintf("%s/api%s", c.ImmichURL, endpoint)
 	var reqBody io.Reader
 	if body != nil {
@@ -35,18 +37,20 @@ func (c *IMMICHClient) request(endpoint, method, apiKey string, body interface{}
 		reqBody = bytes.NewReader(b)
 	}
 
-	req, err := http.NewRequest(method, url, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
+	_, span := startUpstreamSpan(ctx, "immich.request", req, endpoint)
+	setRequestIDHeader(ctx, req)
 	req.Header.Set("Content-Type", "application/json")
-	// only start with albums add apiKey
-	if method == http.MethodGet && strings.HasPrefix(endpoint, "/albums") {
-		req.Header.Set("x-api-key", apiKey)
-	}
 
-	resp, err := http.DefaultClient.Do(req)
+	start := time.Now()
+	// share-key endpoints are called without an API key
+	resp, err := c.AlbumsKeys.doAuthorized(req, apiKey)
 	if err != nil {
+		observeUpstream(endpoint, start, 0, err)
+		endUpstreamSpan(span, 0, err)
 		return fmt.Errorf("do request: %w", err)
 	}
 	defer func() {
@@ -54,10 +58,11 @@ func (c *IMMICHClient) request(endpoint, method, apiKey string, body interface{}
 			log.Warnf("failed to close response body: %v", err)
 		}
 	}()
+	observeUpstream(endpoint, start, resp.StatusCode, nil)
+	endUpstreamSpan(span, resp.StatusCode, nil)
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		b, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("immich api error on endpoint %s: %d %s: %s", url, resp.StatusCode, resp.Status, string(b))
+		return newAPIError(url, resp)
 	}
 
 	if out != nil {
@@ -66,43 +71,112 @@ func (c *IMMICHClient) request(endpoint, method, apiKey string, body interface{}
 	return nil
 }
 
-func (c *IMMICHClient) GetAlbumInfo(albumID string, withoutAssets bool) (AlbumInfo, error) {
+// APIError is a response from Immich with a non-2xx status.
+type APIError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func newAPIError(url string, resp *http.Response) *APIError {
+	b, _ := io.ReadAll(resp.Body)
+	return &APIError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status, Body: string(b)}
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("immich api error on endpoint %s: %d %s: %s", e.URL, e.StatusCode, e.Status, e.Body)
+}
+
+// hasStatus reports whether err is an APIError with the given status code.
+func hasStatus(err error, status int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+// upstreamUnavailable reports whether err means that Immich could not
+// answer, as opposed to refusing the request.
+func upstreamUnavailable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500
+	}
+	return err != nil && !errors.Is(err, ErrAlbumNotFound)
+}
+
+// ErrAlbumNotFound is returned for albums that no configured API key can see.
+var ErrAlbumNotFound = errors.New("album not found")
+
+func (c *IMMICHClient) GetAlbumInfo(ctx context.Context, albumID string, withoutAssets bool) (AlbumInfo, error) {
 	var result AlbumInfo
 	endpoint := fmt.Sprintf("/albums/%s?withoutAssets=%t", albumID, withoutAssets)
-	apiKey := c.AlbumsKeys.GetAlbumKey(albumID)
-	err := c.request(endpoint, http.MethodGet, apiKey, nil, &result)
+	apiKey := c.AlbumsKeys.GetAlbumKey(ctx, albumID)
+	if apiKey == "" {
+		return result, ErrAlbumNotFound
+	}
+	err := c.request(ctx, endpoint, http.MethodGet, apiKey, nil, &result)
+	if hasStatus(err, http.StatusUnauthorized) {
+		// the key was revoked: drop its albums and try the key that has them now
+		c.AlbumsKeys.RevokeKey(apiKey, err)
+		if apiKey = c.AlbumsKeys.GetAlbumKey(ctx, albumID); apiKey == "" {
+			return AlbumInfo{}, ErrAlbumNotFound
+		}
+		result = AlbumInfo{}
+		err = c.request(ctx, endpoint, http.MethodGet, apiKey, nil, &result)
+	}
 	return result, err
 }
 
-func (c *IMMICHClient) GetSharedLinksInfo(key string) (SharedLinkInfo, error) {
+func (c *IMMICHClient) GetSharedLinksInfo(ctx context.Context, key string) (SharedLinkInfo, error) {
 	var result SharedLinkInfo
 	endpoint := fmt.Sprintf("/shared-links/me?key=%s", key)
-	err := c.request(endpoint, http.MethodGet, "", nil, &result)
+	err := c.request(ctx, endpoint, http.MethodGet, "", nil, &result)
 	return result, err
 }
 
-func (c *IMMICHClient) GetAssetInfo(assetID string, shareKey string) (AssetInfo, error) {
+func (c *IMMICHClient) GetAssetInfo(ctx context.Context, assetID string, shareKey string) (AssetInfo, error) {
 	var result AssetInfo
 	endpoint := fmt.Sprintf("/assets/%s?key=%s", assetID, shareKey)
-	err := c.request(endpoint, http.MethodGet, "", nil, &result)
+	err := c.request(ctx, endpoint, http.MethodGet, "", nil, &result)
 	return result, err
 }
 
-func (c *IMMICHClient) GetAssetThumbnail(assetID, size, shareKey string) ([]byte, error) {
-	return c.GetAssetFile(
+// Ping checks that Immich is reachable, without authentication.
+func (c *IMMICHClient) Ping(ctx context.Context) error {
+	var result struct {
+		Res string `json:"res"`
+	}
+	if err := c.request(ctx, "/server/ping", http.MethodGet, "", nil, &result); err != nil {
+		return err
+	}
+	if result.Res != "pong" {
+		return fmt.Errorf("unexpected ping response %q", result.Res)
+	}
+	return nil
+}
+
+// GetMyUser returns the user owning apiKey, which also validates the key.
+func (c *IMMICHClient) GetMyUser(ctx context.Context, apiKey string) (UserInfo, error) {
+	var result UserInfo
+	err := c.request(ctx, "/users/me", http.MethodGet, apiKey, nil, &result)
+	return result, err
+}
+
+func (c *IMMICHClient) GetAssetThumbnail(ctx context.Context, assetID, size, shareKey string) ([]byte, error) {
+	return c.GetAssetFile(ctx,
 		fmt.Sprintf("/assets/%s/thumbnail", assetID),
 		map[string]string{"size": size, "key": shareKey},
 	)
 }
 
-func (c *IMMICHClient) GetAssetOriginal(assetID, shareKey string) ([]byte, error) {
-	return c.GetAssetFile(
+func (c *IMMICHClient) GetAssetOriginal(ctx context.Context, assetID, shareKey string) ([]byte, error) {
+	return c.GetAssetFile(ctx,
 		fmt.Sprintf("/assets/%s/original", assetID),
 		map[string]string{"key": shareKey},
 	)
 }
 
-func (c *IMMICHClient) GetAssetFile(path string, query map[string]string) ([]byte, error) {
+func (c *IMMICHClient) GetAssetFile(ctx context.Context, path string, query map[string]string) ([]byte, error) {
 	endpoint := path
 	if len(query) > 0 {
 		q := url.Values{}
@@ -113,13 +187,18 @@ func (c *IMMICHClient) GetAssetFile(path string, query map[string]string) ([]byt
 	}
 	url := fmt.Sprintf("%s/api%s", c.ImmichURL, endpoint)
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
 	}
+	_, span := startUpstreamSpan(ctx, "immich.GetAssetFile", req, path)
+	setRequestIDHeader(ctx, req)
 
+	start := time.Now()
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
+		observeUpstream(path, start, 0, err)
+		endUpstreamSpan(span, 0, err)
 		return nil, fmt.Errorf("do request: %w", err)
 	}
 	defer func() {
@@ -127,11 +206,14 @@ func (c *IMMICHClient) GetAssetFile(path string, query map[string]string) ([]byt
 			log.Warnf("failed to close response body: %v", err)
 		}
 	}()
+	observeUpstream(path, start, resp.StatusCode, nil)
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		b, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("immich api error on endpoint %s: %d %s: %s", url, resp.StatusCode, resp.Status, string(b))
+		endUpstreamSpan(span, resp.StatusCode, nil)
+		return nil, newAPIError(url, resp)
 	}
+	// the span covers the body transfer, which dominates for originals
 	b, err := io.ReadAll(resp.Body)
+	endUpstreamSpan(span, resp.StatusCode, err)
 	if err != nil {
 		return nil, fmt.Errorf("read body: %w", err)
 	}
@@ -152,6 +234,7 @@ type AlbumInfo struct {
 	LastModifiedAssetTimestamp string      `json:"lastModifiedAssetTimestamp"`
 	Order                      string      `json:"order"`
 	OwnerId                    string      `json:"ownerId"`
+	Owner                      *UserInfo   `json:"owner,omitempty"` // not forwarded to clients
 	Shared                     bool        `json:"shared"`
 	StartDate                  string      `json:"startDate"`
 	UpdatedAt                  string      `json:"updatedAt"`
@@ -188,6 +271,35 @@ type AssetInfo struct {
 	Tags        []string `json:"tags,omitempty"`
 }
 
+// TakenAt returns when the asset was captured: the EXIF original date,
+// else the file creation date. It is zero if neither parses.
+func (a AssetInfo) TakenAt() time.Time {
+	if a.ExifInfo != nil && a.ExifInfo.DateTimeOriginal != nil {
+		if t, err := time.Parse(time.RFC3339Nano, *a.ExifInfo.DateTimeOriginal); err == nil {
+			return t
+		}
+	}
+	t, _ := time.Parse(time.RFC3339Nano, a.FileCreatedAt)
+	return t
+}
+
+// Dimensions returns the size of the asset as displayed, that is with the
+// EXIF orientation applied. ok is false if EXIF does not tell the size.
+func (a AssetInfo) Dimensions() (width, height int, ok bool) {
+	exif := a.ExifInfo
+	if exif == nil || exif.ExifImageWidth == nil || exif.ExifImageHeight == nil {
+		return 0, 0, false
+	}
+	width, height = *exif.ExifImageWidth, *exif.ExifImageHeight
+	if exif.Orientation != nil {
+		switch *exif.Orientation {
+		case "5", "6", "7", "8": // rotated by 90 or 270 degrees
+			width, height = height, width
+		}
+	}
+	return width, height, width > 0 && height > 0
+}
+
 type ExifInfo struct {
 	Make                 *string  `json:"make,omitempty"`
 	Model                *string  `json:"model,omitempty"`
@@ -215,6 +327,12 @@ type ExifInfo struct {
 	Rating               *int     `json:"rating,omitempty"`
 }
 
+type UserInfo struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
 type SharedLinkInfo struct {
 	Album         *AlbumInfo  `json:"album,omitempty"`
 	Assets        []AssetInfo `json:"assets,omitempty"`
@@ -224,4 +342,5 @@ type SharedLinkInfo struct {
 	Key           *string     `json:"key,omitempty"`
 	AllowDownload *bool       `json:"allowDownload,omitempty"`
 	AllowUpload   *bool       `json:"allowUpload,omitempty"`
+	ShowMetadata  *bool       `json:"showMetadata,omitempty"`
 }
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	keyHealthOK      = "ok"
+	keyHealthRevoked = "revoked" // Immich answered 401
+	keyHealthError   = "error"   // the check failed otherwise, e.g. Immich is down
+
+	permAlbumRead = "album.read"
+	permAssetRead = "asset.read"
+)
+
+// KeyHealth is the outcome of the last check of one API key.
+type KeyHealth struct {
+	Status             string    `json:"status"`
+	User               string    `json:"user,omitempty"` // email of the key's user
+	CheckedAt          time.Time `json:"checkedAt"`
+	Error              string    `json:"error,omitempty"`
+	MissingPermissions []string  `json:"missingPermissions,omitempty"`
+}
+
+// KeyHealth returns a snapshot of the last check per API key. Keys not
+// checked yet are missing.
+func (a *AlbumsKeys) KeyHealth() map[string]KeyHealth {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	health := make(map[string]KeyHealth, len(a.health))
+	for key, h := range a.health {
+		h.MissingPermissions = slices.Clone(h.MissingPermissions)
+		health[key] = h
+	}
+	return health
+}
+
+// RevokeKey marks key revoked after Immich refused it with reason, a 401,
+// and drops the albums mapped to it. Without sync they are looked up again
+// on the next request; with sync the next sync moves them to another key
+// that can see them. It returns the number of albums dropped.
+func (a *AlbumsKeys) RevokeKey(key string, reason error) int {
+	a.lock.Lock()
+	if !slices.Contains(a.ApiKeys, key) {
+		a.lock.Unlock()
+		return 0
+	}
+	h := a.health[key]
+	wasRevoked := h.Status == keyHealthRevoked
+	h.Status, h.Error, h.CheckedAt = keyHealthRevoked, reason.Error(), time.Now()
+	a.health[key] = h
+	evicted := 0
+	for albumId, k := range a.AlbumsKeys {
+		if k == key {
+			delete(a.AlbumsKeys, albumId)
+			delete(a.lastSeen, albumId)
+			delete(a.meta, albumId)
+			evicted++
+		}
+	}
+	a.lock.Unlock()
+
+	a.probes.dropList(key)
+	apiKeyUp.WithLabelValues(hashKey(key)).Set(0)
+	if !wasRevoked {
+		apiKeyRevocations.WithLabelValues(hashKey(key)).Inc()
+		log.Warnf("API key %s was refused by Immich, dropped its %d album(s): %v", redactKey(key), evicted, reason)
+	}
+	return evicted
+}
+
+// keyAccepted clears the revoked status of key after Immich accepted it again.
+func (a *AlbumsKeys) keyAccepted(key string) {
+	a.lock.Lock()
+	h, ok := a.health[key]
+	if !ok || h.Status != keyHealthRevoked {
+		a.lock.Unlock()
+		return
+	}
+	h.Status, h.Error, h.CheckedAt = keyHealthOK, "", time.Now()
+	a.health[key] = h
+	a.lock.Unlock()
+	apiKeyUp.WithLabelValues(hashKey(key)).Set(1)
+	log.Infof("API key %s is accepted by Immich again", redactKey(key))
+}
+
+func (a *AlbumsKeys) setKeyHealth(key string, h KeyHealth) {
+	a.lock.Lock()
+	if !slices.Contains(a.ApiKeys, key) {
+		a.lock.Unlock()
+		return
+	}
+	prev := a.health[key]
+	a.health[key] = h
+	a.lock.Unlock()
+
+	fingerprint := hashKey(key)
+	up := 0.0
+	if h.Status == keyHealthOK {
+		up = 1
+	}
+	apiKeyUp.WithLabelValues(fingerprint).Set(up)
+	for _, perm := range []string{permAlbumRead, permAssetRead} {
+		missing := 0.0
+		if slices.Contains(h.MissingPermissions, perm) {
+			missing = 1
+		}
+		apiKeyMissingPermission.WithLabelValues(fingerprint, perm).Set(missing)
+	}
+	if prev.Status == keyHealthRevoked && h.Status == keyHealthOK {
+		log.Infof("API key %s is accepted by Immich again", redactKey(key))
+	}
+	if len(h.MissingPermissions) > 0 && !slices.Equal(prev.MissingPermissions, h.MissingPermissions) {
+		log.Warnf("API key %s lacks permissions %v", redactKey(key), h.MissingPermissions)
+	}
+}
+
+func forgetKeyHealthMetrics(key string) {
+	fingerprint := hashKey(key)
+	apiKeyUp.DeleteLabelValues(fingerprint)
+	for _, perm := range []string{permAlbumRead, permAssetRead} {
+		apiKeyMissingPermission.DeleteLabelValues(fingerprint, perm)
+	}
+}
+
+// SetKeyCheck changes the interval of a running key monitor, 0 pausing it,
+// and whether it checks the permissions of each key.
+func (a *AlbumsKeys) SetKeyCheck(interval time.Duration, permissions bool) {
+	a.checkPerms.Store(permissions)
+	// keep only the latest pending change
+	select {
+	case <-a.keyCheckCh:
+	default:
+	}
+	a.keyCheckCh <- interval
+}
+
+// StartKeyMonitor checks every API key right away and then every interval,
+// until ctx is done. An interval of 0 leaves the monitor idle until
+// SetKeyCheck sets one.
+func (a *AlbumsKeys) StartKeyMonitor(ctx context.Context, client *IMMICHClient, interval time.Duration) {
+	if interval > 0 {
+		log.Infof("Checking API keys every %s", interval)
+	}
+	go func() {
+		var ticker *time.Ticker
+		var tick <-chan time.Time
+		reset := func(d time.Duration) {
+			if ticker != nil {
+				ticker.Stop()
+				ticker, tick = nil, nil
+			}
+			if d > 0 {
+				ticker = time.NewTicker(d)
+				tick = ticker.C
+			}
+		}
+		defer reset(0)
+		reset(interval)
+		if interval > 0 {
+			a.checkKeys(ctx, client)
+		}
+		for {
+			select {
+			case <-tick:
+				a.checkKeys(ctx, client)
+			case d := <-a.keyCheckCh:
+				if d == interval {
+					continue
+				}
+				log.Infof("API key check interval changed to %s", d)
+				interval = d
+				reset(d)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// checkKeys checks all API keys concurrently.
+func (a *AlbumsKeys) checkKeys(ctx context.Context, client *IMMICHClient) {
+	var wg sync.WaitGroup
+	for _, key := range a.Keys() {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			a.checkKey(ctx, client, key)
+		}(key)
+	}
+	wg.Wait()
+}
+
+// checkKey validates key against /users/me. A 401 revokes it. A 403 is
+// fine: a key restricted to album and asset permissions may not read its
+// own user.
+func (a *AlbumsKeys) checkKey(ctx context.Context, client *IMMICHClient, key string) {
+	h := KeyHealth{Status: keyHealthOK, CheckedAt: time.Now()}
+	user, err := client.GetMyUser(ctx, key)
+	switch {
+	case hasStatus(err, http.StatusUnauthorized):
+		a.RevokeKey(key, err)
+		return
+	case hasStatus(err, http.StatusForbidden):
+	case err != nil:
+		if ctx.Err() != nil {
+			return
+		}
+		log.Errorf("Failed to check API key %s: %v", redactKey(key), err)
+		if a.KeyHealth()[key].Status == keyHealthRevoked {
+			return // still revoked as far as we know
+		}
+		h.Status, h.Error = keyHealthError, err.Error()
+	default:
+		h.User = user.Email
+	}
+	if h.Status == keyHealthOK && a.checkPerms.Load() {
+		missing, err := a.missingPermissions(ctx, client, key)
+		if err != nil {
+			log.Warnf("Failed to check the permissions of API key %s: %v", redactKey(key), err)
+		}
+		h.MissingPermissions = missing
+	}
+	a.setKeyHealth(key, h)
+}
+
+// missingPermissions tries what the proxy does with key: listing and
+// reading albums, and reading one of their assets. Immich answers 403 for
+// a permission the key lacks. Asset read is only checked when the key can
+// see an album with assets.
+func (a *AlbumsKeys) missingPermissions(ctx context.Context, client *IMMICHClient, key string) ([]string, error) {
+	var albums []AlbumInfo
+	err := client.request(ctx, "/albums", http.MethodGet, key, nil, &albums)
+	if hasStatus(err, http.StatusForbidden) {
+		return []string{permAlbumRead}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(albums, func(album AlbumInfo) bool { return album.AssetCount > 0 })
+	if i < 0 {
+		return nil, nil
+	}
+	var album AlbumInfo
+	endpoint := fmt.Sprintf("/albums/%s?withoutAssets=false", albums[i].ID)
+	if err := client.request(ctx, endpoint, http.MethodGet, key, nil, &album); err != nil {
+		if hasStatus(err, http.StatusForbidden) {
+			return []string{permAlbumRead}, nil
+		}
+		return nil, err
+	}
+	if len(album.Assets) == 0 {
+		return nil, nil
+	}
+	err = client.request(ctx, "/assets/"+album.Assets[0].ID, http.MethodGet, key, nil, nil)
+	if hasStatus(err, http.StatusForbidden) {
+		return []string{permAssetRead}, nil
+	}
+	return nil, err
+}
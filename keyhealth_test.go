@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestRevokeKey(t *testing.T) {
+	const key1, key2 = "key-one", "key-two"
+	var lock sync.Mutex // to protect revoked
+	revoked := map[string]bool{}
+	lists := map[string][]AlbumInfo{key1: {{ID: "a1"}, {ID: "a2"}}, key2: {{ID: "a3"}}}
+	immich := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("x-api-key")
+		lock.Lock()
+		refused := revoked[key]
+		lock.Unlock()
+		if refused {
+			http.Error(w, `{"message":"Invalid API key"}`, http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/users/me":
+			json.NewEncoder(w).Encode(map[string]string{"id": "u1", "email": key + "@example.com"})
+		case "/api/albums":
+			json.NewEncoder(w).Encode(lists[key])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer immich.Close()
+	setRevoked := func(key string, v bool) {
+		lock.Lock()
+		revoked[key] = v
+		lock.Unlock()
+	}
+
+	ctx := context.Background()
+	a := NewAlbumsKeys([]string{key1, key2}, true, immich.URL)
+	client := NewIMMICHClient(immich.URL, a)
+	a.fetchAllAlbums(ctx, immich.URL)
+	mapped := map[string]string{"a1": key1, "a2": key1, "a3": key2}
+	if got := a.Albums(); !maps.Equal(got, mapped) {
+		t.Fatalf("albums after the first sync = %v, want %v", got, mapped)
+	}
+
+	setRevoked(key1, true)
+	a.checkKeys(ctx, client)
+	if got, want := a.Albums(), map[string]string{"a3": key2}; !maps.Equal(got, want) {
+		t.Errorf("albums after key1 was refused = %v, want %v", got, want)
+	}
+	health := a.KeyHealth()
+	if health[key1].Status != keyHealthRevoked || health[key2].Status != keyHealthOK {
+		t.Errorf("key health = %v, want key1 revoked and key2 ok", health)
+	}
+
+	setRevoked(key1, false)
+	a.checkKeys(ctx, client)
+	if h := a.KeyHealth()[key1]; h.Status != keyHealthOK || h.Error != "" {
+		t.Errorf("key1 health after a successful check = %+v, want ok", h)
+	}
+	a.fetchAllAlbums(ctx, immich.URL)
+	if got := a.Albums(); !maps.Equal(got, mapped) {
+		t.Errorf("albums after the next sync = %v, want %v", got, mapped)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+var (
+	// matches key=<value> in query strings, including share keys and api keys
+	keyParamPattern  = regexp.MustCompile(`(?i)\b((?:api_?)?key=)[^&\s"']+`)
+	requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+	secretsFormatter = &redactingFormatter{}
+)
+
+// setupLogging configures level and format of the global logger. Every entry
+// goes through redactingFormatter, whatever the format.
+func setupLogging(level, format string) error {
+	logLevel, err := log.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %w", err)
+	}
+	var inner log.Formatter
+	switch format {
+	case "", "text":
+		inner = &log.TextFormatter{
+			FullTimestamp: true,
+			ForceColors:   true,
+		}
+	case "json":
+		inner = &log.JSONFormatter{}
+	default:
+		return fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+	log.SetLevel(logLevel)
+	secretsFormatter.inner = inner
+	log.SetFormatter(secretsFormatter)
+	return nil
+}
+
+// registerSecrets sets the values redacted from every log line, in addition to key= query values.
+func registerSecrets(secrets []string) {
+	s := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret != "" {
+			s = append(s, secret)
+		}
+	}
+	secretsFormatter.secrets.Store(&s)
+}
+
+// redactingFormatter replaces registered secrets and key= query values in the
+// message and string fields by their fingerprint before handing the entry to
+// the inner formatter.
+type redactingFormatter struct {
+	inner   log.Formatter
+	secrets atomic.Pointer[[]string]
+}
+
+func (f *redactingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	e := entry.Dup()
+	e.Level = entry.Level
+	e.Caller = entry.Caller
+	e.Message = f.redact(entry.Message)
+	for k, v := range e.Data {
+		switch val := v.(type) {
+		case string:
+			e.Data[k] = f.redact(val)
+		case error:
+			e.Data[k] = f.redact(val.Error())
+		}
+	}
+	return f.inner.Format(e)
+}
+
+func (f *redactingFormatter) redact(s string) string {
+	if secrets := f.secrets.Load(); secrets != nil {
+		for _, secret := range *secrets {
+			if strings.Contains(s, secret) {
+				s = strings.ReplaceAll(s, secret, redactKey(secret))
+			}
+		}
+	}
+	return keyParamPattern.ReplaceAllStringFunc(s, func(param string) string {
+		name, value, _ := strings.Cut(param, "=")
+		if strings.HasPrefix(value, "[key:") {
+			return param // a registered secret, already redacted
+		}
+		return name + "=" + redactKey(value)
+	})
+}
+
+// redactKey returns a loggable stand-in for a secret.
+func redactKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	return "[key:" + hashKey(key) + "]"
+}
+
+// requestIDMiddleware tags each request with an ID, taken from X-Request-ID
+// when the client sends a sane one, and echoes it in the response.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		log.Warnf("failed to generate request id: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestID returns the ID assigned by requestIDMiddleware, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// setRequestIDHeader forwards the request ID to Immich so both logs can be correlated.
+func setRequestIDHeader(ctx context.Context, req *http.Request) {
+	if id := RequestID(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+}
+
+// requestLogger returns a logger tagged with the request ID carried by ctx.
+func requestLogger(ctx context.Context) *log.Entry {
+	if id := RequestID(ctx); id != "" {
+		return log.WithField("request_id", id)
+	}
+	return log.NewEntry(log.StandardLogger())
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRedactingFormatter(t *testing.T) {
+	const apiKey, shareKey = "immich-api-key-0123456789", "share-key-abc"
+	for name, inner := range map[string]log.Formatter{
+		"text": &log.TextFormatter{DisableColors: true},
+		"json": &log.JSONFormatter{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := &redactingFormatter{inner: inner}
+			f.secrets.Store(&[]string{apiKey})
+			entry := log.WithFields(log.Fields{
+				"url":   "/api/assets/as1/thumbnail?size=preview&key=" + shareKey,
+				"error": errors.New("GET /api/albums with " + apiKey + ": 401"),
+			})
+			entry.Message = "Fetching album a1 with API key " + apiKey + " for /share?api_key=" + apiKey
+
+			out, err := f.Format(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			line := string(out)
+			for _, secret := range []string{apiKey, shareKey} {
+				if strings.Contains(line, secret) {
+					t.Errorf("log line holds %q: %s", secret, line)
+				}
+			}
+			for _, want := range []string{
+				"API key " + redactKey(apiKey),
+				"api_key=" + redactKey(apiKey),
+				"key=" + redactKey(shareKey),
+				"with " + redactKey(apiKey) + ": 401",
+			} {
+				if !strings.Contains(line, want) {
+					t.Errorf("log line lacks %q: %s", want, line)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// loginKeyPrefix marks the stand-ins for API keys that identify logins in
+// the key list, so albums, rules, state and metrics treat both alike.
+const loginKeyPrefix = "login:"
+
+// LoginConfig is an Immich user the proxy logs in as, for users who cannot
+// create an API key. Both fields take the same references as API keys.
+type LoginConfig struct {
+	Email    string `yaml:"email"`
+	Password string `yaml:"password" secret:"true"`
+}
+
+// loginKey returns the key list entry of the login for email.
+func loginKey(email string) string {
+	return loginKeyPrefix + email
+}
+
+func isLoginKey(key string) bool {
+	return strings.HasPrefix(key, loginKeyPrefix)
+}
+
+// loginSessions holds the passwords of the configured logins and the access
+// token of each login once it logged in.
+type loginSessions struct {
+	immichURL string
+
+	lock      sync.Mutex        // to protect passwords and tokens
+	passwords map[string]string // login key -> password
+	tokens    map[string]string // login key -> access token
+
+	loginLock sync.Mutex // held while logging in, so concurrent requests log in once
+}
+
+func newLoginSessions(immichURL string) *loginSessions {
+	return &loginSessions{
+		immichURL: immichURL,
+		passwords: make(map[string]string),
+		tokens:    make(map[string]string),
+	}
+}
+
+// set replaces the configured logins. Tokens of logins that are gone or
+// whose password changed are dropped.
+func (s *loginSessions) set(logins []LoginConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	passwords := make(map[string]string, len(logins))
+	for _, l := range logins {
+		key := loginKey(l.Email)
+		passwords[key] = l.Password
+		if s.passwords[key] != l.Password {
+			delete(s.tokens, key)
+		}
+	}
+	for key := range s.tokens {
+		if _, ok := passwords[key]; !ok {
+			delete(s.tokens, key)
+		}
+	}
+	s.passwords = passwords
+}
+
+// token returns the access token of the login key, logging in if there is none.
+func (s *loginSessions) token(ctx context.Context, key string) (string, error) {
+	s.lock.Lock()
+	token := s.tokens[key]
+	s.lock.Unlock()
+	if token != "" {
+		return token, nil
+	}
+
+	s.loginLock.Lock()
+	defer s.loginLock.Unlock()
+	s.lock.Lock()
+	token = s.tokens[key]
+	password, ok := s.passwords[key]
+	s.lock.Unlock()
+	if token != "" {
+		return token, nil // another request logged in meanwhile
+	}
+	if !ok {
+		return "", fmt.Errorf("login %s is not configured", redactKey(key))
+	}
+	token, err := s.login(ctx, strings.TrimPrefix(key, loginKeyPrefix), password)
+	if err != nil {
+		return "", err
+	}
+	s.lock.Lock()
+	if s.passwords[key] == password {
+		s.tokens[key] = token
+	}
+	s.lock.Unlock()
+	return token, nil
+}
+
+// expire drops token of the login key if it is still current, so the next
+// request logs in again.
+func (s *loginSessions) expire(key, token string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.tokens[key] == token {
+		delete(s.tokens, key)
+	}
+}
+
+// login calls /auth/login and returns the access token.
+func (s *loginSessions) login(ctx context.Context, email, password string) (string, error) {
+	endpoint := "/auth/login"
+	url := fmt.Sprintf("%s/api%s", s.immichURL, endpoint)
+	body, err := json.Marshal(map[string]string{"email": email, "password": password})
+	if err != nil {
+		return "", fmt.Errorf("marshal body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("create request: %w", err)
+	}
+	_, span := startUpstreamSpan(ctx, "immich.login", req, endpoint)
+	setRequestIDHeader(ctx, req)
+	req.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		observeUpstream(endpoint, start, 0, err)
+		endUpstreamSpan(span, 0, err)
+		return "", fmt.Errorf("do request: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("failed to close response body: %v", err)
+		}
+	}()
+	observeUpstream(endpoint, start, resp.StatusCode, nil)
+	endUpstreamSpan(span, resp.StatusCode, nil)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", newAPIError(url, resp)
+	}
+	var result struct {
+		AccessToken string `json:"accessToken"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("decode response: %w", err)
+	}
+	if result.AccessToken == "" {
+		return "", fmt.Errorf("login response without access token")
+	}
+	log.Infof("Logged in to Immich as %s", redactKey(loginKey(email)))
+	return result.AccessToken, nil
+}
+
+// doAuthorized sends req with the credentials of key: the access token of a
+// login, or else the API key in x-api-key. When Immich refuses the token of
+// a login, e.g. because it expired, it logs in again and resends req once.
+// An empty key sends req as it is.
+func (a *AlbumsKeys) doAuthorized(req *http.Request, key string) (*http.Response, error) {
+	if !isLoginKey(key) {
+		if key != "" {
+			req.Header.Set("x-api-key", key)
+		}
+		return http.DefaultClient.Do(req)
+	}
+	token, err := a.sessions.token(req.Context(), key)
+	if err != nil {
+		return nil, fmt.Errorf("log in: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if err := resp.Body.Close(); err != nil {
+		log.Warnf("failed to close response body: %v", err)
+	}
+	log.Debugf("Access token of %s was refused, logging in again", redactKey(key))
+	a.sessions.expire(key, token)
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, fmt.Errorf("rewind body: %w", err)
+		}
+	}
+	if token, err = a.sessions.token(req.Context(), key); err != nil {
+		return nil, fmt.Errorf("log in: %w", err)
+	}
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultClient.Do(retry)
+}
+
+// SetLogins replaces the configured logins; their keys must be part of the
+// keys passed to SetAPIKeys.
+func (a *AlbumsKeys) SetLogins(logins []LoginConfig) {
+	a.sessions.set(logins)
+}
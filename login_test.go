@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDoAuthorizedLogsInAgain(t *testing.T) {
+	// every login hands out a new token; the first one is refused, as if it
+	// had expired
+	var logins, requests atomic.Int32
+	immich := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/auth/login":
+			n := logins.Add(1)
+			fmt.Fprintf(w, `{"accessToken":"token%d"}`, n)
+		case "/api/echo":
+			requests.Add(1)
+			if r.Header.Get("Authorization") != "Bearer token2" {
+				http.Error(w, `{"message":"Invalid user token"}`, http.StatusUnauthorized)
+				return
+			}
+			io.Copy(w, r.Body)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer immich.Close()
+
+	key := loginKey("user@example.com")
+	a := NewAlbumsKeys([]string{key}, false, immich.URL)
+	a.SetLogins([]LoginConfig{{Email: "user@example.com", Password: "pw"}})
+
+	send := func() (int, string) {
+		t.Helper()
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, immich.URL+"/api/echo", strings.NewReader("payload"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := a.doAuthorized(req, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if status, body := send(); status != http.StatusOK || body != "payload" {
+		t.Errorf("first request: %d %q, want 200 with the body resent", status, body)
+	}
+	if n, m := logins.Load(), requests.Load(); n != 2 || m != 2 {
+		t.Errorf("%d logins and %d requests, want 2 of each: one refused, one retried", n, m)
+	}
+
+	// the new token is kept
+	if status, _ := send(); status != http.StatusOK {
+		t.Errorf("second request: %d, want 200", status)
+	}
+	if n, m := logins.Load(), requests.Load(); n != 2 || m != 3 {
+		t.Errorf("%d logins and %d requests after the second request, want 2 and 3", n, m)
+	}
+}
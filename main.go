@@ -2,49 +2,175 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+const readHeaderTimeout = 10 * time.Second
+
 func main() {
-	cfg, err := loadConfig("config.yaml")
-	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
-	}
-	logLevel, err := log.ParseLevel(cfg.LogLevel)
-	if err != nil {
-		log.Fatalf("Invalid log level: %v", err)
-	}
-	log.SetLevel(logLevel)
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp: true,
-		ForceColors:   true,
-	})
+	os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
+}
 
+// serve runs the proxy with cfg, loaded with opts, until SIGINT/SIGTERM or a
+// listener fails. On a signal it stops accepting connections, drains
+// in-flight requests for up to shutdownTimeout, stops background work and
+// flushes audit logs and traces before returning.
+func serve(opts *globalOptions, cfg *Config) error {
 	log.Infof("proxy started, Listen %s, forwarded to %s", cfg.Listen, cfg.Immich.URL)
 
 	refreshInterval, err := time.ParseDuration(cfg.Immich.AlbumsRefreshInterval)
 	if err != nil {
-		log.Fatalf("Invalid albumsRefreshInterval: %v", err)
+		return fmt.Errorf("invalid albumsRefreshInterval: %w", err)
 	}
-	albumsKeys := NewAlbumsKeys(cfg.Immich.APIKeys, cfg.Immich.AlbumsSyncEnabled, cfg.Immich.URL)
+	// ctx scopes background work: album refresh and config watching
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+
+	shutdownTracing, err := setupTracing(ctx, cfg.Tracing)
+	if err != nil {
+		return fmt.Errorf("set up tracing: %w", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Warnf("failed to flush traces: %v", err)
+		}
+	}()
+
+	albumsKeys := NewAlbumsKeys(cfg.BackendKeys(), cfg.Immich.AlbumsSyncEnabled, cfg.Immich.URL)
+	albumsKeys.SetLogins(cfg.Immich.Logins)
+	registerAlbumsKeysMetrics(albumsKeys)
+	albumsKeys.SetProbeCacheTTLs(cfg.ProbeCacheTTLs())
+	albumsKeys.SetSyncScope(cfg.Immich.SyncScope, cfg.Immich.SharedLinkOnly)
+	albumsKeys.SetAlbumRules(cfg.AlbumRules)
+	registerCache(albumsKeys)
+	registerCache(albumsKeys.probes)
+	if cfg.StateFile != "" {
+		if err := albumsKeys.EnableState(cfg.StateFile); err != nil {
+			log.Warnf("Failed to load album state, starting empty: %v", err)
+		}
+		defer func() {
+			if err := albumsKeys.SaveState(); err != nil {
+				log.Errorf("Failed to save album state: %v", err)
+			}
+		}()
+	}
+	immichService := NewImmichService(NewIMMICHClient(cfg.Immich.URL, albumsKeys))
+	immichService.publicURL = cfg.PublicURL
+	albumsKeys.SetKeyCheck(cfg.KeyCheckInterval(), cfg.Immich.CheckKeyPermissions)
+	albumsKeys.StartKeyMonitor(ctx, immichService.client, cfg.KeyCheckInterval())
+	if cfg.Webhooks.Enabled() {
+		webhooks, err := NewWebhookNotifier(cfg.Webhooks)
+		if err != nil {
+			return fmt.Errorf("set up webhooks: %w", err)
+		}
+		webhooks.Start(ctx)
+		albumsKeys.OnAlbumsUpdated(webhooks.AlbumsUpdated)
+	}
+	registerCache(immichService.shareKeys)
+	registerCache(immichService.snapshots)
+	registerCache(immichService.randomHistory)
+
 	if cfg.Immich.AlbumsSyncEnabled {
 		log.Infof("Albums sync enabled, refreshing every %s", refreshInterval)
 		albumsKeys.StartRefreshing(ctx, refreshInterval, cfg.Immich.URL)
 	}
 
-	immichService := &ImmichService{
-		client: NewIMMICHClient(cfg.Immich.URL, albumsKeys),
+	var audit *AuditLogger
+	if cfg.Audit.Enabled {
+		audit, err = NewAuditLogger(cfg.Audit, cfg.TrustedProxies)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := audit.Close(); err != nil {
+				log.Warnf("failed to close audit log: %v", err)
+			}
+		}()
+		log.Infof("Audit log enabled, writing to %s", cfg.Audit.Path)
 	}
 
-	r := NewRouter(immichService, cfg.GetCORSConfig())
+	var cors atomic.Pointer[CORSConfig]
+	cors.Store(cfg.GetCORSConfig())
+	reloader := NewConfigReloader(opts.configPath, opts.loadConfig, cfg, albumsKeys, &cors)
+	reloader.Watch(ctx)
+
+	stats := NewStats()
+	srv := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           NewRouter(immichService, &cors, audit, stats),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ConnState:         stats.ConnState,
+	}
+	if cfg.TLS.Enabled() {
+		if err := configureTLS(ctx, srv, cfg.TLS); err != nil {
+			return fmt.Errorf("set up TLS: %w", err)
+		}
+	}
+	servers := []*http.Server{srv}
+	if cfg.TLS.RedirectListen != "" {
+		servers = append(servers, newRedirectServer(cfg.TLS.RedirectListen, cfg.Listen))
+	}
+	if cfg.Admin.Listen != "" {
+		admin := NewAdminServer(albumsKeys, reloader, stats, NewHealthChecker(immichService.client), cfg.Admin.Token)
+		adminSrv, err := newAdminServer(ctx, cfg.Admin, admin)
+		if err != nil {
+			return fmt.Errorf("set up admin listener: %w", err)
+		}
+		servers = append(servers, adminSrv)
+	}
 
-	log.Infof("[INFO] Immich Proxy Server started on %s", cfg.Listen)
-	if err := http.ListenAndServe(cfg.Listen, r); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	errCh := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			var err error
+			if srv.TLSConfig != nil {
+				log.Infof("Listening on %s (TLS)", srv.Addr)
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				log.Infof("Listening on %s", srv.Addr)
+				err = srv.ListenAndServe()
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errCh <- fmt.Errorf("listen on %s: %w", srv.Addr, err)
+			}
+		}(srv)
+	}
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err = <-errCh:
+		log.Errorf("Server failed, shutting down: %v", err)
+	case <-sigCtx.Done():
+		log.Info("Shutdown signal received, draining connections (send again to force)")
+	}
+	// restore default signal handling so a second signal kills the process
+	stop()
+	cancel()
+
+	timeout, parseErr := time.ParseDuration(reloader.Current().ShutdownTimeout)
+	if parseErr != nil {
+		timeout, _ = time.ParseDuration(defaultShutdownTimeout)
+	}
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), timeout)
+	defer cancelShutdown()
+	for _, srv := range servers {
+		if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil {
+			log.Warnf("Drain of %s did not finish within %s, closing remaining connections: %v", srv.Addr, timeout, shutdownErr)
+			if closeErr := srv.Close(); closeErr != nil {
+				log.Warnf("failed to close %s: %v", srv.Addr, closeErr)
+			}
+		}
 	}
+	log.Info("All listeners stopped, flushing")
+	return err
 }
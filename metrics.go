@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "immich_proxy_http_requests_total",
+		Help: "HTTP requests served, by route and status.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "immich_proxy_http_request_duration_seconds",
+		Help:    "HTTP request latency, by route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	httpBytesServed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "immich_proxy_http_response_bytes_total",
+		Help: "Response body bytes served, by route.",
+	}, []string{"route"})
+
+	upstreamRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "immich_proxy_upstream_request_duration_seconds",
+		Help:    "Latency of requests to Immich, by endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	upstreamErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "immich_proxy_upstream_errors_total",
+		Help: "Failed requests to Immich, by endpoint and reason (transport or HTTP status).",
+	}, []string{"endpoint", "reason"})
+
+	albumsSyncDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "immich_proxy_albums_sync_duration_seconds",
+		Help:    "Duration of each album sync, by outcome.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	albumsSyncLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "immich_proxy_albums_sync_last_success_timestamp_seconds",
+		Help: "Unix time of the last album sync in which every API key succeeded.",
+	})
+
+	albumsSyncChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "immich_proxy_albums_sync_changes_total",
+		Help: "Albums added, removed or moved to another key by syncs, by change.",
+	}, []string{"change"})
+
+	albumProbes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "immich_proxy_album_probes_total",
+		Help: "Album key lookups without sync, by result (found, miss, cached_miss).",
+	}, []string{"result"})
+
+	apiKeyFetchFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "immich_proxy_api_key_fetch_failures_total",
+		Help: "Album list fetch failures, by API key fingerprint.",
+	}, []string{"key"})
+
+	apiKeyUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "immich_proxy_api_key_up",
+		Help: "Whether the last check of an API key succeeded (1) or it was revoked or failed (0), by fingerprint.",
+	}, []string{"key"})
+
+	apiKeyRevocations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "immich_proxy_api_key_revocations_total",
+		Help: "Times an API key was refused by Immich with 401, by fingerprint.",
+	}, []string{"key"})
+
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "immich_proxy_webhook_deliveries_total",
+		Help: "Webhook delivery attempts, by result (delivered, retried, dead_lettered).",
+	}, []string{"result"})
+
+	apiKeyMissingPermission = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "immich_proxy_api_key_missing_permission",
+		Help: "Whether an API key lacks a permission the proxy needs (1), by fingerprint and permission.",
+	}, []string{"key", "permission"})
+)
+
+// registerAlbumsKeysMetrics exposes the size of the album->key map.
+func registerAlbumsKeysMetrics(a *AlbumsKeys) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "immich_proxy_albums_keys",
+		Help: "Number of albums currently mapped to an API key.",
+	}, func() float64 {
+		return float64(a.Len())
+	})
+}
+
+// metricsMiddleware records request counts, latency and bytes served per
+// route template, so IDs never end up in label values.
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := "unmatched"
+		if cur := mux.CurrentRoute(r); cur != nil {
+			if tpl, err := cur.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		status := strconv.Itoa(rec.status)
+		httpRequestsTotal.WithLabelValues(route, r.Method, status).Inc()
+		httpRequestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+		httpBytesServed.WithLabelValues(route).Add(float64(rec.bytes))
+	})
+}
+
+// observeUpstream records the latency and outcome of a request to Immich.
+// status is 0 when the request failed before a response was received.
+func observeUpstream(endpoint string, start time.Time, status int, err error) {
+	label := endpointLabel(endpoint)
+	upstreamRequestDuration.WithLabelValues(label).Observe(time.Since(start).Seconds())
+	switch {
+	case status == 0 && err != nil:
+		upstreamErrorsTotal.WithLabelValues(label, "transport").Inc()
+	case status < 200 || status >= 300:
+		upstreamErrorsTotal.WithLabelValues(label, strconv.Itoa(status)).Inc()
+	}
+}
+
+// endpointLabel turns an Immich endpoint such as /albums/<id>?withoutAssets=true
+// into a low-cardinality label such as /albums/{id}.
+func endpointLabel(endpoint string) string {
+	if i := strings.IndexByte(endpoint, '?'); i >= 0 {
+		endpoint = endpoint[:i]
+	}
+	parts := strings.Split(endpoint, "/")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] == "" {
+			continue
+		}
+		switch parts[i-1] {
+		case "albums", "assets":
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxCachedMisses bounds the negative cache so a flood of made-up album IDs
+// cannot grow it without limit.
+const maxCachedMisses = 10000
+
+// probeCache backs GetAlbumKeyWithoutSync. It remembers album IDs that no
+// key could see for missTTL, and the album list of each key for listTTL so
+// a burst of lookups shares one /albums call per key.
+type probeCache struct {
+	lock    sync.Mutex // to protect the fields below
+	missTTL time.Duration
+	listTTL time.Duration
+	misses  map[string]time.Time // album ID -> expiry
+	lists   map[string]*albumListCall
+}
+
+// albumListCall is a cached or in-flight album list of one key.
+type albumListCall struct {
+	done    chan struct{} // closed when albums and err are set
+	albums  []AlbumInfo
+	err     error
+	expires time.Time
+}
+
+func newProbeCache(missTTL, listTTL time.Duration) *probeCache {
+	return &probeCache{
+		missTTL: missTTL,
+		listTTL: listTTL,
+		misses:  make(map[string]time.Time),
+		lists:   make(map[string]*albumListCall),
+	}
+}
+
+func (p *probeCache) setTTLs(missTTL, listTTL time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.missTTL, p.listTTL = missTTL, listTTL
+}
+
+func (p *probeCache) isMiss(albumId string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	expires, ok := p.misses[albumId]
+	if ok && time.Now().After(expires) {
+		delete(p.misses, albumId)
+		return false
+	}
+	return ok
+}
+
+func (p *probeCache) addMiss(albumId string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.missTTL <= 0 {
+		return
+	}
+	now := time.Now()
+	if len(p.misses) >= maxCachedMisses {
+		for id, expires := range p.misses {
+			if now.After(expires) {
+				delete(p.misses, id)
+			}
+		}
+		if len(p.misses) >= maxCachedMisses {
+			return
+		}
+	}
+	p.misses[albumId] = now.Add(p.missTTL)
+}
+
+// albumList returns the albums of key, from the cache, from a call already
+// in flight, or from fetch. Failed calls are not cached.
+func (p *probeCache) albumList(ctx context.Context, key string,
+	fetch func(ctx context.Context, key string) ([]AlbumInfo, error)) ([]AlbumInfo, error) {
+	p.lock.Lock()
+	call, ok := p.lists[key]
+	if ok {
+		select {
+		case <-call.done:
+			if call.err != nil || time.Now().After(call.expires) {
+				ok = false
+			}
+		default: // in flight
+		}
+	}
+	if !ok {
+		call = &albumListCall{done: make(chan struct{})}
+		p.lists[key] = call
+		p.lock.Unlock()
+		call.albums, call.err = fetch(ctx, key)
+		p.lock.Lock()
+		call.expires = time.Now().Add(p.listTTL)
+		if call.err != nil || p.listTTL <= 0 {
+			if p.lists[key] == call {
+				delete(p.lists, key)
+			}
+		}
+		p.lock.Unlock()
+		close(call.done)
+		return call.albums, call.err
+	}
+	p.lock.Unlock()
+
+	select {
+	case <-call.done:
+		// the caller that started the call gave up, e.g. because another
+		// key matched first; try again on behalf of this one
+		if errors.Is(call.err, context.Canceled) && ctx.Err() == nil {
+			return p.albumList(ctx, key, fetch)
+		}
+		return call.albums, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Name implements Purger.
+func (p *probeCache) Name() string {
+	return "albumProbes"
+}
+
+// Len counts cached misses and album lists.
+func (p *probeCache) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.misses) + len(p.lists)
+}
+
+// PurgeAlbum forgets a cached miss for albumId and every album list that
+// could be stale about it.
+func (p *probeCache) PurgeAlbum(albumId string) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	n := 0
+	if _, ok := p.misses[albumId]; ok {
+		delete(p.misses, albumId)
+		n++
+	}
+	for key, call := range p.lists {
+		select {
+		case <-call.done:
+		default:
+			continue
+		}
+		delete(p.lists, key)
+		n++
+	}
+	return n
+}
+
+// dropList forgets the album list of key, e.g. when the key was revoked.
+func (p *probeCache) dropList(key string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if call, ok := p.lists[key]; ok {
+		select {
+		case <-call.done:
+			delete(p.lists, key)
+		default: // in flight, not cached when it fails
+		}
+	}
+}
+
+// PurgeAsset implements Purger; assets are not cached here.
+func (p *probeCache) PurgeAsset(string) int {
+	return 0
+}
+
+// GetAlbumKeyWithoutSync finds the key that can see albumId by listing the
+// albums of every key concurrently. The first key found wins and the other
+// lookups are cancelled. An album no key can see is remembered as a miss,
+// unless a key failed and might have held it.
+func (a *AlbumsKeys) GetAlbumKeyWithoutSync(ctx context.Context, albumId string) string {
+	key, _ := a.lookupAlbumKey(ctx, albumId)
+	return key
+}
+
+// lookupAlbumKey implements GetAlbumKeyWithoutSync. complete is false when
+// no key was found but a key failed, so the album may still be one of its.
+func (a *AlbumsKeys) lookupAlbumKey(ctx context.Context, albumId string) (key string, complete bool) {
+	if a.probes.isMiss(albumId) {
+		albumProbes.WithLabelValues("cached_miss").Inc()
+		log.Debugf("Album %s is a cached miss", albumId)
+		return "", true
+	}
+	keys := a.Keys()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type probeResult struct {
+		key   string
+		album *AlbumInfo
+		err   error
+	}
+	results := make(chan probeResult, len(keys))
+	for _, key := range keys {
+		go func(key string) {
+			log.Debugf("Looking for album %s with API key %s", albumId, redactKey(key))
+			albums, err := a.probes.albumList(ctx, key, func(ctx context.Context, key string) ([]AlbumInfo, error) {
+				return a.listAlbums(ctx, a.immageBaseURL, key)
+			})
+			r := probeResult{key: key, err: err}
+			if i := slices.IndexFunc(albums, func(album AlbumInfo) bool { return album.ID == albumId }); i >= 0 {
+				r.album = &albums[i]
+			}
+			results <- r
+		}(key)
+	}
+
+	failed := false
+	for range keys {
+		r := <-results
+		if r.err != nil {
+			if ctx.Err() == nil {
+				log.Errorf("Failed to fetch albums for API key %s: %v", redactKey(r.key), r.err)
+			}
+			if hasStatus(r.err, http.StatusUnauthorized) {
+				a.RevokeKey(r.key, r.err)
+				continue // a revoked key holds no albums, so a miss can still be cached
+			}
+			failed = true
+			continue
+		}
+		a.keyAccepted(r.key)
+		if r.album != nil {
+			albumProbes.WithLabelValues("found").Inc()
+			a.setAlbumKey(*r.album, r.key)
+			return r.key, true
+		}
+	}
+	if !failed {
+		a.probes.addMiss(albumId)
+	}
+	albumProbes.WithLabelValues("miss").Inc()
+	return "", !failed
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// probeImmich is a fake Immich whose keys list the albums in lists. It
+// counts /albums calls; while gate is set, calls wait for it to be closed.
+type probeImmich struct {
+	*httptest.Server
+	calls   atomic.Int32
+	arrived chan struct{} // receives a value for every call
+	lock    sync.Mutex    // to protect gate
+	gate    chan struct{}
+}
+
+func newProbeImmich(t *testing.T, lists map[string][]AlbumInfo) *probeImmich {
+	t.Helper()
+	p := &probeImmich{arrived: make(chan struct{}, 100)}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/albums" {
+			http.NotFound(w, r)
+			return
+		}
+		p.calls.Add(1)
+		p.arrived <- struct{}{}
+		p.lock.Lock()
+		gate := p.gate
+		p.lock.Unlock()
+		if gate != nil {
+			select {
+			case <-gate:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(lists[r.Header.Get("x-api-key")])
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+// hold makes calls wait until the returned function is called.
+func (p *probeImmich) hold() (release func()) {
+	gate := make(chan struct{})
+	p.lock.Lock()
+	p.gate = gate
+	p.lock.Unlock()
+	return func() {
+		p.lock.Lock()
+		p.gate = nil
+		p.lock.Unlock()
+		close(gate)
+	}
+}
+
+func newProbeAlbumsKeys(immich *probeImmich, missTTL, listTTL time.Duration) *AlbumsKeys {
+	a := NewAlbumsKeys([]string{"key-one", "key-two"}, false, immich.URL)
+	a.SetProbeCacheTTLs(missTTL, listTTL)
+	return a
+}
+
+func TestProbeMissCache(t *testing.T) {
+	immich := newProbeImmich(t, map[string][]AlbumInfo{"key-two": {{ID: "a1"}}})
+	a := newProbeAlbumsKeys(immich, 50*time.Millisecond, 0)
+	ctx := context.Background()
+
+	if key, complete := a.lookupAlbumKey(ctx, "a9"); key != "" || !complete {
+		t.Fatalf("lookupAlbumKey(a9) = %q, %v, want a complete miss", key, complete)
+	}
+	if n := immich.calls.Load(); n != 2 {
+		t.Fatalf("%d /albums calls, want one per key", n)
+	}
+	if key, complete := a.lookupAlbumKey(ctx, "a9"); key != "" || !complete {
+		t.Errorf("cached lookupAlbumKey(a9) = %q, %v, want a complete miss", key, complete)
+	}
+	if n := immich.calls.Load(); n != 2 {
+		t.Errorf("%d /albums calls after a cached miss, want 2", n)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	a.GetAlbumKeyWithoutSync(ctx, "a9")
+	if n := immich.calls.Load(); n != 4 {
+		t.Errorf("%d /albums calls after the miss expired, want 4", n)
+	}
+	if key := a.GetAlbumKeyWithoutSync(ctx, "a1"); key != "key-two" {
+		t.Errorf("GetAlbumKeyWithoutSync(a1) = %q, want key-two", key)
+	}
+}
+
+func TestProbeTTLZeroDisablesCache(t *testing.T) {
+	immich := newProbeImmich(t, map[string][]AlbumInfo{"key-two": {{ID: "a1"}}})
+	a := newProbeAlbumsKeys(immich, 0, 0)
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		a.GetAlbumKeyWithoutSync(ctx, "a9")
+		if n := immich.calls.Load(); n != int32(2*i) {
+			t.Fatalf("lookup %d: %d /albums calls, want %d", i, n, 2*i)
+		}
+	}
+	if n := a.probes.Len(); n != 0 {
+		t.Errorf("%d cache entries, want none", n)
+	}
+}
+
+func TestProbeConcurrentLookupsShareCalls(t *testing.T) {
+	immich := newProbeImmich(t, map[string][]AlbumInfo{"key-one": {{ID: "a1"}}, "key-two": {{ID: "a2"}}})
+	a := newProbeAlbumsKeys(immich, 0, 0)
+	release := immich.hold()
+
+	albums := []string{"a1", "a2", "a3", "a4", "a5", "a6"}
+	keys := make([]string, len(albums))
+	var wg sync.WaitGroup
+	for i, albumID := range albums {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys[i] = a.GetAlbumKeyWithoutSync(context.Background(), albumID)
+		}()
+	}
+	<-immich.arrived
+	<-immich.arrived
+	time.Sleep(50 * time.Millisecond) // let every lookup join the calls in flight
+	release()
+	wg.Wait()
+
+	if n := immich.calls.Load(); n != 2 {
+		t.Errorf("%d /albums calls for %d concurrent lookups, want one per key", n, len(albums))
+	}
+	want := []string{"key-one", "key-two", "", "", "", ""}
+	for i := range albums {
+		if keys[i] != want[i] {
+			t.Errorf("GetAlbumKeyWithoutSync(%s) = %q, want %q", albums[i], keys[i], want[i])
+		}
+	}
+}
+
+func TestProbeCancellation(t *testing.T) {
+	immich := newProbeImmich(t, map[string][]AlbumInfo{"key-one": {{ID: "a1"}}})
+	a := NewAlbumsKeys([]string{"key-one"}, false, immich.URL)
+	a.SetProbeCacheTTLs(time.Minute, time.Minute)
+	release := immich.hold()
+
+	// a lookup whose client gives up
+	ctx, cancel := context.WithCancel(context.Background())
+	type result struct {
+		key      string
+		complete bool
+	}
+	cancelled := make(chan result, 1)
+	go func() {
+		key, complete := a.lookupAlbumKey(ctx, "a1")
+		cancelled <- result{key, complete}
+	}()
+	<-immich.arrived
+
+	// joins the call in flight, then makes its own once that one is cancelled
+	found := make(chan string, 1)
+	go func() { found <- a.GetAlbumKeyWithoutSync(context.Background(), "a1") }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if r := <-cancelled; r.key != "" || r.complete {
+		t.Errorf("cancelled lookupAlbumKey(a1) = %q, %v, want an incomplete miss", r.key, r.complete)
+	}
+	<-immich.arrived
+	release()
+	if key := <-found; key != "key-one" {
+		t.Errorf("GetAlbumKeyWithoutSync(a1) = %q after another lookup was cancelled, want key-one", key)
+	}
+	if a.probes.isMiss("a1") {
+		t.Error("cancelled lookup cached a1 as a miss")
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxRandomClients = 1000
+	randomHistoryTTL = 24 * time.Hour // a client's history is forgotten after this long unused
+)
+
+// RandomAssetResponse is the asset picked by /api/albums/{id}/random.
+type RandomAssetResponse struct {
+	FrameAsset
+	Type       string `json:"type"`
+	IsFavorite bool   `json:"isFavorite"`
+	Rating     int    `json:"rating,omitempty"`
+	// Remaining is how many matching assets a client has not been served
+	// yet, with no repeats only.
+	Remaining *int `json:"remaining,omitempty"`
+	Stale     bool `json:"stale"`
+}
+
+// randomFilter selects the assets the random endpoint may pick.
+type randomFilter struct {
+	Type        string // IMAGE or VIDEO
+	Orientation string
+	MinRating   int
+	Favorites   bool
+	From, To    time.Time // capture date range, To exclusive
+}
+
+// usesMetadata reports whether the filter looks at EXIF the shared link may
+// hide. Orientation is left out: it only needs the size of the asset, which
+// every response carries anyway, and the preview itself shows.
+func (f randomFilter) usesMetadata() bool {
+	return f.MinRating > 0 || !f.From.IsZero() || !f.To.IsZero()
+}
+
+func (f randomFilter) match(a AssetInfo) bool {
+	if a.IsTrashed || (f.Type != "" && a.Type != f.Type) || (f.Favorites && !a.IsFavorite) {
+		return false
+	}
+	if f.Orientation != "" && assetOrientation(a) != f.Orientation {
+		return false
+	}
+	if f.MinRating > 0 && assetRating(a) < f.MinRating {
+		return false
+	}
+	if !f.From.IsZero() || !f.To.IsZero() {
+		t := a.TakenAt()
+		if t.IsZero() || t.Before(f.From) || (!f.To.IsZero() && !t.Before(f.To)) {
+			return false
+		}
+	}
+	return true
+}
+
+func assetRating(a AssetInfo) int {
+	if a.ExifInfo == nil || a.ExifInfo.Rating == nil {
+		return 0
+	}
+	return *a.ExifInfo.Rating
+}
+
+// parseRandomFilter reads the filter of a random request, answering the
+// request with an error if it is invalid.
+func parseRandomFilter(w http.ResponseWriter, r *http.Request) (randomFilter, bool) {
+	q := r.URL.Query()
+	var f randomFilter
+	switch t := strings.ToUpper(q.Get("type")); t {
+	case "", "IMAGE", "VIDEO":
+		f.Type = t
+	default:
+		http.Error(w, "Invalid type, must be image or video", http.StatusBadRequest)
+		return f, false
+	}
+	var ok bool
+	if f.Orientation, ok = parseOrientation(w, q.Get("orientation")); !ok {
+		return f, false
+	}
+	if v := q.Get("minRating"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 5 {
+			http.Error(w, "Invalid minRating, must be 0 to 5", http.StatusBadRequest)
+			return f, false
+		}
+		f.MinRating = n
+	}
+	if v := q.Get("favorites"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "Invalid favorites", http.StatusBadRequest)
+			return f, false
+		}
+		f.Favorites = b
+	}
+	for name, t := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		if d, err := time.Parse(time.DateOnly, v); err == nil {
+			if name == "to" {
+				d = d.AddDate(0, 0, 1) // the whole day
+			}
+			*t = d
+		} else if *t, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "Invalid "+name+", must be a date or an RFC 3339 time", http.StatusBadRequest)
+			return f, false
+		}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		http.Error(w, "Invalid date range, from must be before to", http.StatusBadRequest)
+		return f, false
+	}
+	return f, true
+}
+
+// RandomAssetHandler processes requests to /api/albums/{id}/random?key=&client=&redirect=
+// and the filters type, orientation, minRating, favorites, from and to.
+func (s *ImmichService) RandomAssetHandler(w http.ResponseWriter, r *http.Request) {
+	logger := requestLogger(r.Context())
+	albumID := mux.Vars(r)["id"]
+	filter, ok := parseRandomFilter(w, r)
+	if !ok {
+		return
+	}
+	redirect := false
+	if v := r.URL.Query().Get("redirect"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "Invalid redirect", http.StatusBadRequest)
+			return
+		}
+		redirect = b
+	}
+	shared, ok := s.sharedAlbumAccess(w, r, albumID)
+	if !ok {
+		return
+	}
+	if filter.usesMetadata() && !shared.ShowMetadata {
+		http.Error(w, "Rating and date filters need a shared link that shows metadata", http.StatusForbidden)
+		return
+	}
+
+	album, stale, err := s.albumAssets(r.Context(), albumID)
+	if errors.Is(err, ErrAlbumNotFound) {
+		http.Error(w, "Album not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Errorf("Failed to get album info: %v", err)
+		http.Error(w, "Failed to get album info", http.StatusInternalServerError)
+		return
+	}
+	var candidates []AssetInfo
+	for _, asset := range album.Assets {
+		if filter.match(asset) {
+			candidates = append(candidates, asset)
+		}
+	}
+	if len(candidates) == 0 {
+		http.Error(w, "No asset matches", http.StatusNotFound)
+		return
+	}
+
+	var asset AssetInfo
+	var remaining *int
+	if client := r.URL.Query().Get("client"); client != "" {
+		var n int
+		asset, n = s.randomHistory.pick(client, albumID, candidates)
+		remaining = &n
+	} else {
+		asset = candidates[rand.IntN(len(candidates))]
+	}
+
+	links := newAssetLinks(s.publicURL, GetShareKey(r))
+	w.Header().Set("Cache-Control", "no-store")
+	if redirect {
+		http.Redirect(w, r, links.asset(asset.ID, "thumbnail", "preview"), http.StatusFound)
+		return
+	}
+	resp := RandomAssetResponse{
+		FrameAsset: newFrameAsset(asset, links, shared),
+		Type:       asset.Type,
+		IsFavorite: asset.IsFavorite,
+		Remaining:  remaining,
+		Stale:      stale,
+	}
+	if shared.ShowMetadata {
+		resp.Rating = assetRating(asset)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf("Failed to encode random asset: %v", err)
+	}
+}
+
+// randomHistory remembers, per client token and album, which assets the
+// random endpoint served, so clients asking for no repeats see every asset
+// once before any comes again. Tokens are held by fingerprint only.
+type randomHistory struct {
+	lock    sync.Mutex // to protect entries
+	entries map[string]*servedAssets
+}
+
+type servedAssets struct {
+	albumID string
+	served  map[string]bool
+	last    string
+	used    time.Time
+}
+
+func newRandomHistory() *randomHistory {
+	return &randomHistory{entries: make(map[string]*servedAssets)}
+}
+
+// pick returns a random candidate the client was not served yet, starting
+// over once all were, and how many remain after it.
+func (h *randomHistory) pick(client, albumID string, candidates []AssetInfo) (AssetInfo, int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	id := hashKey(client) + "/" + albumID
+	entry, ok := h.entries[id]
+	if !ok {
+		h.evict()
+		entry = &servedAssets{albumID: albumID, served: make(map[string]bool)}
+		h.entries[id] = entry
+	}
+	entry.used = time.Now()
+
+	var fresh []AssetInfo
+	for _, a := range candidates {
+		if !entry.served[a.ID] {
+			fresh = append(fresh, a)
+		}
+	}
+	if len(fresh) == 0 {
+		// all served: start over, without showing the last one twice in a row
+		clear(entry.served)
+		for _, a := range candidates {
+			if a.ID != entry.last || len(candidates) == 1 {
+				fresh = append(fresh, a)
+			}
+		}
+	}
+	asset := fresh[rand.IntN(len(fresh))]
+	entry.served[asset.ID] = true
+	entry.last = asset.ID
+	remaining := 0
+	for _, a := range candidates {
+		if !entry.served[a.ID] {
+			remaining++
+		}
+	}
+	return asset, remaining
+}
+
+// evict drops histories unused for randomHistoryTTL and, when still full,
+// the least recently used one. h.lock must be held.
+func (h *randomHistory) evict() {
+	var oldest string
+	for id, e := range h.entries {
+		if time.Since(e.used) > randomHistoryTTL {
+			delete(h.entries, id)
+		} else if oldest == "" || e.used.Before(h.entries[oldest].used) {
+			oldest = id
+		}
+	}
+	if len(h.entries) >= maxRandomClients {
+		delete(h.entries, oldest)
+	}
+}
+
+// Name implements Purger.
+func (h *randomHistory) Name() string {
+	return "randomHistory"
+}
+
+func (h *randomHistory) Len() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.entries)
+}
+
+// PurgeAlbum forgets what clients were served of albumID.
+func (h *randomHistory) PurgeAlbum(albumID string) int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	n := 0
+	for id, e := range h.entries {
+		if e.albumID == albumID {
+			delete(h.entries, id)
+			n++
+		}
+	}
+	return n
+}
+
+// PurgeAsset implements Purger; served assets are forgotten per album only.
+func (h *randomHistory) PurgeAsset(string) int {
+	return 0
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRandomHistoryPickNoRepeats(t *testing.T) {
+	candidates := []AssetInfo{{ID: "x"}, {ID: "y"}, {ID: "z"}}
+	h := newRandomHistory()
+	for round := 0; round < 3; round++ {
+		served := make(map[string]bool)
+		for i := 0; i < len(candidates); i++ {
+			asset, remaining := h.pick("client", "a1", candidates)
+			if served[asset.ID] {
+				t.Fatalf("round %d: %s served twice", round, asset.ID)
+			}
+			served[asset.ID] = true
+			if want := len(candidates) - 1 - i; remaining != want {
+				t.Errorf("round %d: remaining = %d, want %d", round, remaining, want)
+			}
+		}
+	}
+}
+
+func TestRandomHistoryPickStartsOverWithoutRepeat(t *testing.T) {
+	candidates := []AssetInfo{{ID: "x"}, {ID: "y"}}
+	for i := 0; i < 20; i++ {
+		h := newRandomHistory()
+		h.pick("client", "a1", candidates)
+		last, _ := h.pick("client", "a1", candidates)
+		if next, _ := h.pick("client", "a1", candidates); next.ID == last.ID {
+			t.Fatalf("%s shown twice in a row when starting over", last.ID)
+		}
+	}
+	// a single candidate is all there is to show
+	h := newRandomHistory()
+	for i := 0; i < 3; i++ {
+		if asset, _ := h.pick("client", "a1", candidates[:1]); asset.ID != "x" {
+			t.Fatalf("picked %s, want x", asset.ID)
+		}
+	}
+}
+
+func TestRandomHistoryPerClientAndAlbum(t *testing.T) {
+	candidates := []AssetInfo{{ID: "x"}, {ID: "y"}}
+	h := newRandomHistory()
+	h.pick("client", "a1", candidates)
+	if _, remaining := h.pick("other", "a1", candidates); remaining != 1 {
+		t.Errorf("another client has %d remaining, want 1", remaining)
+	}
+	if _, remaining := h.pick("client", "a2", candidates); remaining != 1 {
+		t.Errorf("another album has %d remaining, want 1", remaining)
+	}
+	if n := h.PurgeAlbum("a1"); n != 2 {
+		t.Errorf("PurgeAlbum(a1) dropped %d histories, want 2", n)
+	}
+	if h.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", h.Len())
+	}
+}
+
+func TestRandomFilterMatch(t *testing.T) {
+	rating := 4
+	taken := "2024-07-01T10:00:00Z"
+	photo := AssetInfo{
+		ID: "p", Type: "IMAGE", IsFavorite: true,
+		ExifInfo: &ExifInfo{DateTimeOriginal: &taken, Rating: &rating},
+	}
+	day := func(s string) time.Time {
+		d, _ := time.Parse(time.DateOnly, s)
+		return d
+	}
+	tests := []struct {
+		name   string
+		filter randomFilter
+		asset  AssetInfo
+		want   bool
+	}{
+		{name: "no filter", asset: photo, want: true},
+		{name: "trashed", asset: AssetInfo{IsTrashed: true}, want: false},
+		{name: "type", filter: randomFilter{Type: "VIDEO"}, asset: photo, want: false},
+		{name: "favorites", filter: randomFilter{Favorites: true}, asset: AssetInfo{ID: "q"}, want: false},
+		{name: "rating met", filter: randomFilter{MinRating: 4}, asset: photo, want: true},
+		{name: "rating missed", filter: randomFilter{MinRating: 5}, asset: photo, want: false},
+		{name: "in range", filter: randomFilter{From: day("2024-07-01"), To: day("2024-07-02")}, asset: photo, want: true},
+		{name: "to is exclusive", filter: randomFilter{To: day("2024-07-01")}, asset: photo, want: false},
+		{name: "no date", filter: randomFilter{From: day("2000-01-01")}, asset: AssetInfo{ID: "q"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.match(tt.asset); got != tt.want {
+				t.Errorf("match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRandomFilter(t *testing.T) {
+	tests := []struct {
+		query string
+		ok    bool
+		want  randomFilter
+	}{
+		{query: "", ok: true},
+		{query: "type=image&minRating=3&favorites=true", ok: true, want: randomFilter{Type: "IMAGE", MinRating: 3, Favorites: true}},
+		{query: "to=2024-07-01", ok: true, want: randomFilter{To: time.Date(2024, 7, 2, 0, 0, 0, 0, time.UTC)}},
+		{query: "type=audio"},
+		{query: "minRating=6"},
+		{query: "orientation=round"},
+		{query: "from=yesterday"},
+		{query: "from=2024-07-02&to=2024-07-01"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		got, ok := parseRandomFilter(w, httptest.NewRequest(http.MethodGet, "/api/albums/a1/random?"+tt.query, nil))
+		if ok != tt.ok {
+			t.Errorf("%q: ok = %v, want %v (%d %s)", tt.query, ok, tt.ok, w.Code, w.Body)
+			continue
+		}
+		if ok && got != tt.want {
+			t.Errorf("%q: filter = %+v, want %+v", tt.query, got, tt.want)
+		}
+		if !ok && w.Code != http.StatusBadRequest {
+			t.Errorf("%q: status %d, want 400", tt.query, w.Code)
+		}
+	}
+}
+
+func TestRandomFilterUsesMetadata(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{query: "", want: false},
+		{query: "type=video&favorites=true", want: false},
+		{query: "orientation=portrait", want: false}, // the size is in every response
+		{query: "minRating=1", want: true},
+		{query: "from=2024-07-01", want: true},
+		{query: "to=2024-07-01", want: true},
+	}
+	for _, tt := range tests {
+		f, ok := parseRandomFilter(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/albums/a1/random?"+tt.query, nil))
+		if !ok {
+			t.Fatalf("%q: invalid filter", tt.query)
+		}
+		if got := f.usesMetadata(); got != tt.want {
+			t.Errorf("%q: usesMetadata() = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
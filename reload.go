@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const configPollInterval = 2 * time.Second
+
+// restartOnlyFields are config paths (or prefixes ending in ".") that are
+// read once at startup; changing them on reload only logs a warning, and
+// the startup value stays in effect.
+var restartOnlyFields = []string{
+	"listen",
+	"publicURL",
+	"immich.url",
+	"immich.albumsSyncEnabled",
+	"trustedProxies",
+	"stateFile",
+	"admin.",
+	"audit.",
+	"tracing.",
+	"webhooks.",
+	"tls.",
+}
+
+// ConfigReloader reloads the config on SIGHUP or when the file changes and
+// applies the reloadable parts: API keys and logins, CORS, log level and format, the
+// album refresh interval, the sync scope, the album rules, the lookup
+// cache TTLs and the API key checks. A config that fails to load or validate is rejected and the
+// running one is kept.
+type ConfigReloader struct {
+	path       string
+	load       func() (*Config, error)
+	albumsKeys *AlbumsKeys
+	cors       *atomic.Pointer[CORSConfig]
+
+	lock    sync.Mutex // to protect current
+	current *Config
+}
+
+// NewConfigReloader watches path; load must return the config with any
+// command-line overrides applied, so they survive a reload.
+func NewConfigReloader(path string, load func() (*Config, error), cfg *Config,
+	albumsKeys *AlbumsKeys, cors *atomic.Pointer[CORSConfig]) *ConfigReloader {
+	return &ConfigReloader{
+		path:       path,
+		load:       load,
+		albumsKeys: albumsKeys,
+		cors:       cors,
+		current:    cfg,
+	}
+}
+
+// Current returns the config currently in effect.
+func (r *ConfigReloader) Current() *Config {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.current
+}
+
+func (r *ConfigReloader) Reload() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	cfg, err := r.load()
+	if err != nil {
+		return fmt.Errorf("load %s: %w", r.path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	changes := diffConfigs(r.current, cfg)
+	if len(changes) == 0 {
+		log.Infof("Config reloaded from %s, nothing changed", r.path)
+		return nil
+	}
+	// the logger is swapped first so the diff is logged in the new format
+	if err := setupLogging(cfg.LogLevel, cfg.LogFormat); err != nil {
+		return err
+	}
+	registerSecrets(cfg.Secrets())
+	r.albumsKeys.SetLogins(cfg.Immich.Logins)
+	r.albumsKeys.SetAPIKeys(cfg.BackendKeys())
+	r.albumsKeys.SetProbeCacheTTLs(cfg.ProbeCacheTTLs())
+	r.albumsKeys.SetSyncScope(cfg.Immich.SyncScope, cfg.Immich.SharedLinkOnly)
+	r.albumsKeys.SetAlbumRules(cfg.AlbumRules)
+	r.albumsKeys.SetKeyCheck(cfg.KeyCheckInterval(), cfg.Immich.CheckKeyPermissions)
+	cors := cfg.Cors
+	r.cors.Store(&cors)
+	if d, err := time.ParseDuration(cfg.Immich.AlbumsRefreshInterval); err == nil {
+		if cfg.Immich.AlbumsRefreshInterval != r.current.Immich.AlbumsRefreshInterval {
+			r.albumsKeys.SetRefreshInterval(d)
+		}
+	}
+
+	log.Infof("Config reloaded from %s, %d change(s):", r.path, len(changes))
+	for _, c := range changes {
+		if c.restartOnly() {
+			log.Warnf("  %s (requires restart)", c)
+		} else {
+			log.Infof("  %s", c)
+		}
+	}
+	// what was read at startup stays in effect, and is what Current reports
+	keepRestartOnlyFields(r.current, cfg)
+	r.current = cfg
+	return nil
+}
+
+// Watch reloads on SIGHUP and whenever the config file's size or mtime
+// changes, until ctx is done.
+func (r *ConfigReloader) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(configPollInterval)
+	last, _ := os.Stat(r.path)
+
+	go func() {
+		defer signal.Stop(hup)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hup:
+				log.Info("SIGHUP received, reloading config")
+			case <-ticker.C:
+				info, err := os.Stat(r.path)
+				if err != nil || !fileChanged(last, info) {
+					continue
+				}
+				last = info
+				log.Infof("%s changed, reloading config", r.path)
+			case <-ctx.Done():
+				return
+			}
+			if err := r.Reload(); err != nil {
+				log.Errorf("Config reload failed, keeping previous config: %v", err)
+			}
+		}
+	}()
+}
+
+func fileChanged(last, cur os.FileInfo) bool {
+	if last == nil {
+		return true
+	}
+	return !cur.ModTime().Equal(last.ModTime()) || cur.Size() != last.Size()
+}
+
+// configChange is one field that differs between two configs.
+type configChange struct {
+	Path     string
+	Old, New string
+}
+
+func (c configChange) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Path, c.Old, c.New)
+}
+
+func (c configChange) restartOnly() bool {
+	return isRestartOnly(c.Path)
+}
+
+func isRestartOnly(path string) bool {
+	for _, f := range restartOnlyFields {
+		if path == f || (strings.HasSuffix(f, ".") && strings.HasPrefix(path, f)) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepRestartOnlyFields copies the restart-only fields of old into cur.
+func keepRestartOnlyFields(old, cur *Config) {
+	before := make(map[string]reflect.Value)
+	_ = walkConfig(reflect.ValueOf(old).Elem(), nil, func(f configField) error {
+		before[f.YAMLPath()] = f.Value
+		return nil
+	})
+	_ = walkConfig(reflect.ValueOf(cur).Elem(), nil, func(f configField) error {
+		if path := f.YAMLPath(); isRestartOnly(path) {
+			f.Value.Set(before[path])
+		}
+		return nil
+	})
+}
+
+// diffConfigs lists the fields that differ, with secrets masked.
+func diffConfigs(old, cur *Config) []configChange {
+	before := flattenConfig(old.Masked())
+	var changes []configChange
+	_ = walkConfig(reflect.ValueOf(cur.Masked()).Elem(), nil, func(f configField) error {
+		path := f.YAMLPath()
+		value := fmt.Sprint(f.Value.Interface())
+		if before[path] != value {
+			changes = append(changes, configChange{Path: path, Old: before[path], New: value})
+		}
+		return nil
+	})
+	return changes
+}
+
+func flattenConfig(cfg *Config) map[string]string {
+	values := make(map[string]string)
+	_ = walkConfig(reflect.ValueOf(cfg).Elem(), nil, func(f configField) error {
+		values[f.YAMLPath()] = fmt.Sprint(f.Value.Interface())
+		return nil
+	})
+	return values
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReloadKeepsRestartOnlyFields(t *testing.T) {
+	// the Immich the proxy started with, and the one a reload points to
+	newImmich := func(calls *atomic.Int32) *httptest.Server {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/albums" {
+				calls.Add(1)
+			}
+			w.Write([]byte("[]"))
+		}))
+		t.Cleanup(s.Close)
+		return s
+	}
+	var startupCalls, reloadedCalls atomic.Int32
+	startup, reloaded := newImmich(&startupCalls), newImmich(&reloadedCalls)
+
+	cfg := validConfig()
+	cfg.Immich.URL = startup.URL
+	load := func() (*Config, error) {
+		c := validConfig()
+		c.Immich.URL = reloaded.URL
+		c.Cors.AllowOrigin = "https://photos.example.com"
+		return c, nil
+	}
+	albumsKeys := NewAlbumsKeys(cfg.Immich.APIKeys, true, cfg.Immich.URL)
+	var cors atomic.Pointer[CORSConfig]
+	cors.Store(&cfg.Cors)
+	reloader := NewConfigReloader("config.yaml", load, cfg, albumsKeys, &cors)
+	t.Cleanup(func() { registerSecrets(nil) }) // Reload registers the test key
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := reloader.Current().Cors.AllowOrigin; got != "https://photos.example.com" {
+		t.Errorf("cors.allowOrigin = %q, want the reloaded value", got)
+	}
+	admin := NewAdminServer(albumsKeys, reloader, nil, nil, "")
+	admin.sync(context.Background())
+	if startupCalls.Load() == 0 || reloadedCalls.Load() != 0 {
+		t.Errorf("sync asked the startup Immich %d times and the reloaded one %d times",
+			startupCalls.Load(), reloadedCalls.Load())
+	}
+
+	w := httptest.NewRecorder()
+	admin.ConfigHandler(w, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+	var config map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config["immich.url"] != startup.URL {
+		t.Errorf("/admin/config immich.url = %q, want %q", config["immich.url"], startup.URL)
+	}
+}
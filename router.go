@@ -1,35 +1,52 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/gorilla/mux"
 )
 
 // NewRouter creates and returns a mux.Router with all routes registered
-func NewRouter(immichService *ImmichService, corsConfig *CORSConfig) *mux.Router {
+// corsConfig is read on every request so it can be swapped on reload.
+// audit and stats may be nil, in which case no access records are written
+// and no traffic is counted.
+func NewRouter(immichService *ImmichService, corsConfig *atomic.Pointer[CORSConfig], audit *AuditLogger, stats *Stats) *mux.Router {
 	r := mux.NewRouter()
 
-	r.HandleFunc(`/api/albums/{id:[^/]+}`, immichService.AlbumHandler).Methods("GET")
-	r.HandleFunc(`/api/shared-links/me`, immichService.SharedLinksHandler).Methods("GET")
-	r.HandleFunc(`/api/assets/{id:[^/]+}`, immichService.AssetHandler).Methods("GET")
-	r.HandleFunc(`/api/assets/{id:[^/]+}/thumbnail`, immichService.MakeAssetHandler(
+	health := NewHealthChecker(immichService.client)
+	r.HandleFunc(`/healthz`, health.HealthzHandler).Methods("GET")
+	r.HandleFunc(`/readyz`, health.ReadyzHandler).Methods("GET")
+
+	r.HandleFunc(`/api/albums/{id:[^/]+}`, audit.Middleware("album", immichService.AlbumHandler)).Methods("GET")
+	r.HandleFunc(`/api/albums/{id:[^/]+}/frame`, audit.Middleware("frame", immichService.FrameAssetsHandler)).Methods("GET")
+	r.HandleFunc(`/api/albums/{id:[^/]+}/random`, audit.Middleware("random", immichService.RandomAssetHandler)).Methods("GET")
+	r.HandleFunc(`/api/shared-links/me`, immichService.RequireAllowedAlbum(immichService.SharedLinksHandler)).Methods("GET")
+	r.HandleFunc(`/api/assets/{id:[^/]+}`, audit.Middleware("asset", immichService.RequireAllowedAlbum(immichService.AssetHandler))).Methods("GET")
+	r.HandleFunc(`/api/assets/{id:[^/]+}/thumbnail`, audit.Middleware("thumbnail", immichService.RequireAllowedAlbum(immichService.MakeAssetHandler(
 		[]string{"shareKey", "assetID", "size"},
-		func(params map[string]string) ([]byte, error) {
-			return immichService.client.GetAssetThumbnail(params["assetID"], params["size"], params["shareKey"])
+		func(ctx context.Context, params map[string]string) ([]byte, error) {
+			return immichService.client.GetAssetThumbnail(ctx, params["assetID"], params["size"], params["shareKey"])
 		},
 		"image/jpeg",
-	)).Methods("GET")
-	r.HandleFunc(`/api/assets/{id:[^/]+}/original`, immichService.MakeAssetHandler(
+	)))).Methods("GET")
+	r.HandleFunc(`/api/assets/{id:[^/]+}/original`, audit.Middleware("original", immichService.RequireAllowedAlbum(immichService.MakeAssetHandler(
 		[]string{"shareKey", "assetID"},
-		func(params map[string]string) ([]byte, error) {
-			return immichService.client.GetAssetOriginal(params["assetID"], params["shareKey"])
+		func(ctx context.Context, params map[string]string) ([]byte, error) {
+			return immichService.client.GetAssetOriginal(ctx, params["assetID"], params["shareKey"])
 		},
 		"image/jpeg",
-	)).Methods("GET")
+	)))).Methods("GET")
+
+	r.HandleFunc(`/feeds/albums/{id:[^/]+}.atom`, audit.Middleware("feed", immichService.FeedHandler)).Methods("GET")
+	r.HandleFunc(`/frame/{id:[^/]+}`, audit.Middleware("frame", immichService.FrameHandler)).Methods("GET")
+	r.HandleFunc(`/g/{id:[^/]+}`, audit.Middleware("gallery", immichService.GalleryHandler)).Methods("GET")
 
 	r.PathPrefix("/").HandlerFunc(ProxyHandler)
 
+	r.Use(requestIDMiddleware, metricsMiddleware, tracingMiddleware, stats.Middleware)
+
 	if corsConfig != nil {
 		r.Use(func(next http.Handler) http.Handler {
 			return corsMiddleware(next, corsConfig)
@@ -38,8 +55,13 @@ func NewRouter(immichService *ImmichService, corsConfig *CORSConfig) *mux.Router
 	return r
 }
 
-func corsMiddleware(next http.Handler, corsConfig *CORSConfig) http.Handler {
+func corsMiddleware(next http.Handler, cors *atomic.Pointer[CORSConfig]) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		corsConfig := cors.Load()
+		if corsConfig == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
 		w.Header().Set("Access-Control-Allow-Origin", corsConfig.AllowOrigin)
 		w.Header().Set("Access-Control-Allow-Methods", corsConfig.AllowMethods)
 		w.Header().Set("Access-Control-Allow-Headers", corsConfig.AllowHeaders)
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// AlbumRule limits the albums served through the proxy. A rule applies to
+// the albums of one API key, or of every key when Key is empty. An album
+// matching any Deny pattern of an applicable rule is refused; if any
+// applicable rule has Allow patterns, the album must match one of them.
+// Patterns are album IDs or globs on the album name, case-insensitive.
+type AlbumRule struct {
+	Key   string   `yaml:"key,omitempty"` // API key fingerprint as shown by `keys check`
+	Allow []string `yaml:"allow,omitempty"`
+	Deny  []string `yaml:"deny,omitempty"`
+}
+
+// albumPolicy evaluates the configured album rules.
+type albumPolicy struct {
+	rules []AlbumRule
+}
+
+func newAlbumPolicy(rules []AlbumRule) *albumPolicy {
+	return &albumPolicy{rules: rules}
+}
+
+// Empty reports whether there are no rules, so everything is allowed.
+func (p *albumPolicy) Empty() bool {
+	return p == nil || len(p.rules) == 0
+}
+
+// hasKeyRules reports whether any rule applies to a single key only.
+func (p *albumPolicy) hasKeyRules() bool {
+	if p.Empty() {
+		return false
+	}
+	for _, rule := range p.rules {
+		if rule.Key != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether the album albumID named name may be served with
+// key. An empty key stands for an album no configured key maps, to which
+// only the rules for every key apply.
+func (p *albumPolicy) Allowed(key, albumID, name string) bool {
+	if p.Empty() {
+		return true
+	}
+	fingerprint := ""
+	if key != "" {
+		fingerprint = hashKey(key)
+	}
+	hasAllow, allowed := false, false
+	for _, rule := range p.rules {
+		if rule.Key != "" && rule.Key != fingerprint {
+			continue
+		}
+		for _, pattern := range rule.Deny {
+			if matchAlbum(pattern, albumID, name) {
+				return false
+			}
+		}
+		if len(rule.Allow) > 0 {
+			hasAllow = true
+			for _, pattern := range rule.Allow {
+				if matchAlbum(pattern, albumID, name) {
+					allowed = true
+				}
+			}
+		}
+	}
+	return !hasAllow || allowed
+}
+
+func matchAlbum(pattern, albumID, name string) bool {
+	if pattern == albumID {
+		return true
+	}
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return ok
+}
+
+func validateAlbumRules(errs *ValidationErrors, rules []AlbumRule) {
+	for i, rule := range rules {
+		p := fmt.Sprintf("albumRules[%d]", i)
+		if rule.Key != "" {
+			if b, err := hex.DecodeString(rule.Key); err != nil || len(b) != 8 {
+				errs.add(p+".key", "invalid fingerprint %q, expected 16 hex digits as shown by `keys check`", rule.Key)
+			}
+		}
+		if len(rule.Allow) == 0 && len(rule.Deny) == 0 {
+			errs.add(p, "needs allow or deny patterns")
+		}
+		for field, patterns := range map[string][]string{"allow": rule.Allow, "deny": rule.Deny} {
+			for j, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					errs.add(fmt.Sprintf("%s.%s[%d]", p, field, j), "invalid pattern %q: %v", pattern, err)
+				}
+			}
+		}
+	}
+}
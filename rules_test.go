@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAlbumPolicyAllowed(t *testing.T) {
+	const key1, key2 = "key-one", "key-two"
+	tests := []struct {
+		name    string
+		rules   []AlbumRule
+		key     string
+		albumID string
+		album   string
+		want    bool
+	}{
+		{name: "no rules", key: key1, albumID: "a1", album: "Holiday", want: true},
+		{
+			name:    "deny by name glob, case-insensitive",
+			rules:   []AlbumRule{{Deny: []string{"private*"}}},
+			key:     key1,
+			albumID: "a1",
+			album:   "Private stuff",
+			want:    false,
+		},
+		{
+			name:    "deny by ID",
+			rules:   []AlbumRule{{Deny: []string{"a1"}}},
+			key:     key1,
+			albumID: "a1",
+			album:   "Holiday",
+			want:    false,
+		},
+		{
+			name:    "not denied",
+			rules:   []AlbumRule{{Deny: []string{"private*"}}},
+			key:     key1,
+			albumID: "a1",
+			album:   "Holiday",
+			want:    true,
+		},
+		{
+			name:    "allow list matches",
+			rules:   []AlbumRule{{Allow: []string{"family*", "a9"}}},
+			key:     key1,
+			albumID: "a1",
+			album:   "Family 2024",
+			want:    true,
+		},
+		{
+			name:    "allow list misses",
+			rules:   []AlbumRule{{Allow: []string{"family*"}}},
+			key:     key1,
+			albumID: "a1",
+			album:   "Holiday",
+			want:    false,
+		},
+		{
+			name:    "deny wins over allow",
+			rules:   []AlbumRule{{Allow: []string{"*"}}, {Deny: []string{"holiday"}}},
+			key:     key1,
+			albumID: "a1",
+			album:   "Holiday",
+			want:    false,
+		},
+		{
+			name:    "rule of the key applies",
+			rules:   []AlbumRule{{Key: hashKey(key1), Deny: []string{"*"}}},
+			key:     key1,
+			albumID: "a1",
+			album:   "Holiday",
+			want:    false,
+		},
+		{
+			name:    "rule of another key does not apply",
+			rules:   []AlbumRule{{Key: hashKey(key2), Deny: []string{"*"}}},
+			key:     key1,
+			albumID: "a1",
+			album:   "Holiday",
+			want:    true,
+		},
+		{
+			name:    "unmapped album only sees rules for every key",
+			rules:   []AlbumRule{{Key: hashKey(key1), Deny: []string{"*"}}, {Allow: []string{"holiday"}}},
+			albumID: "a1",
+			album:   "Holiday",
+			want:    true,
+		},
+		{
+			name:    "allow of another key does not restrict",
+			rules:   []AlbumRule{{Key: hashKey(key2), Allow: []string{"family*"}}},
+			key:     key1,
+			albumID: "a1",
+			album:   "Holiday",
+			want:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newAlbumPolicy(tt.rules).Allowed(tt.key, tt.albumID, tt.album)
+			if got != tt.want {
+				t.Errorf("Allowed(%q, %q, %q) = %v, want %v", tt.key, tt.albumID, tt.album, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlbumPolicyHasKeyRules(t *testing.T) {
+	var nilPolicy *albumPolicy
+	if nilPolicy.hasKeyRules() {
+		t.Error("nil policy has key rules")
+	}
+	if newAlbumPolicy([]AlbumRule{{Deny: []string{"x"}}}).hasKeyRules() {
+		t.Error("policy without key rules has key rules")
+	}
+	if !newAlbumPolicy([]AlbumRule{{Deny: []string{"x"}}, {Key: hashKey("k"), Deny: []string{"y"}}}).hasKeyRules() {
+		t.Error("policy with a key rule has no key rules")
+	}
+}
+
+func TestValidateAlbumRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []AlbumRule
+		paths []string
+	}{
+		{name: "valid", rules: []AlbumRule{{Key: "0f104f3929a6f7e9", Allow: []string{"a*"}}}},
+		{name: "bad fingerprint", rules: []AlbumRule{{Key: "nothex", Deny: []string{"a"}}}, paths: []string{"albumRules[0].key"}},
+		{name: "no patterns", rules: []AlbumRule{{}}, paths: []string{"albumRules[0]"}},
+		{name: "bad pattern", rules: []AlbumRule{{Deny: []string{"ok", "[x"}}}, paths: []string{"albumRules[0].deny[1]"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs ValidationErrors
+			validateAlbumRules(&errs, tt.rules)
+			if len(errs) != len(tt.paths) {
+				t.Fatalf("got errors %v, want paths %v", errs, tt.paths)
+			}
+			for i, p := range tt.paths {
+				if errs[i].Path != p {
+					t.Errorf("error %d at %q, want %q", i, errs[i].Path, p)
+				}
+			}
+		})
+	}
+}
+
+func TestAlbumsKeysAlbumAllowed(t *testing.T) {
+	const key1, key2 = "key-one", "key-two"
+	// what each key lists; albums are added to it after the first sync
+	var lock sync.Mutex
+	lists := map[string][]AlbumInfo{
+		key1: {{ID: "a1", AlbumName: "Family"}, {ID: "a2", AlbumName: "Private stuff"}},
+		key2: {{ID: "a3", AlbumName: "Private too"}},
+	}
+	down := false
+	immich := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if down || r.URL.Path != "/api/albums" {
+			http.Error(w, "{}", http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(lists[r.Header.Get("x-api-key")])
+	}))
+	defer immich.Close()
+
+	a := NewAlbumsKeys([]string{key1, key2}, true, immich.URL)
+	a.SetAlbumRules([]AlbumRule{{Key: hashKey(key1), Allow: []string{"family*"}}})
+	ctx := context.Background()
+
+	// before the first sync, albums are looked up with the keys
+	if a.AlbumAllowed(ctx, "a2", "Private stuff") {
+		t.Error("a2 allowed before the first sync")
+	}
+	a.fetchAllAlbums(ctx, immich.URL)
+
+	lock.Lock()
+	lists[key1] = append(lists[key1], AlbumInfo{ID: "a4", AlbumName: "Holiday"})
+	lists[key2] = append(lists[key2], AlbumInfo{ID: "a5", AlbumName: "Holiday"})
+	lock.Unlock()
+	a.probes.PurgeAlbum("") // drop the album lists cached by the first lookup
+
+	tests := []struct {
+		albumID, name string
+		want          bool
+	}{
+		{"a1", "Family", true},
+		{"a2", "Private stuff", false},
+		{"a2", "Family, renamed by the share link", false}, // judged by the name its key listed
+		{"a3", "Private too", true},                        // key2 has no rules
+		{"a4", "Holiday", false},                           // shared since the sync, key1 allows family only
+		{"a5", "Holiday", true},                            // shared with key2 since the sync
+		{"a9", "Other", true},                              // no key lists it, only rules for every key apply
+	}
+	for _, tt := range tests {
+		if got := a.AlbumAllowed(ctx, tt.albumID, tt.name); got != tt.want {
+			t.Errorf("AlbumAllowed(%q, %q) = %v, want %v", tt.albumID, tt.name, got, tt.want)
+		}
+	}
+	if _, ok := a.AlbumMeta("a2"); ok {
+		t.Error("refused album a2 is mapped")
+	}
+
+	// an album that cannot be looked up may be one a key rule refuses
+	lock.Lock()
+	down = true
+	lock.Unlock()
+	a.probes.PurgeAlbum("")
+	if a.AlbumAllowed(ctx, "a6", "Family too") {
+		t.Error("a6 allowed while the keys cannot be asked")
+	}
+}
+
+func TestRequireAllowedAlbumSingleAssetLinks(t *testing.T) {
+	var albumCalls atomic.Int32
+	immich := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/shared-links/me":
+			link := map[string]any{"type": "INDIVIDUAL"}
+			if r.URL.Query().Get("key") == "album-link" {
+				link = map[string]any{"type": "ALBUM", "album": AlbumInfo{ID: "a1", AlbumName: "Private"}}
+			}
+			json.NewEncoder(w).Encode(link)
+		case "/api/albums":
+			albumCalls.Add(1)
+			w.Write([]byte("[]"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer immich.Close()
+	albumsKeys := NewAlbumsKeys([]string{"key"}, false, immich.URL)
+	albumsKeys.SetAlbumRules([]AlbumRule{{Allow: []string{"public*"}}})
+	service := NewImmichService(NewIMMICHClient(immich.URL, albumsKeys))
+	handler := service.RequireAllowedAlbum(func(w http.ResponseWriter, r *http.Request) {})
+
+	for shareKey, want := range map[string]int{"asset-link": http.StatusOK, "album-link": http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/api/assets/as1/thumbnail?key="+shareKey, nil))
+		if w.Code != want {
+			t.Errorf("%s: status %d, want %d", shareKey, w.Code, want)
+		}
+	}
+	if n := albumCalls.Load(); n != 1 {
+		t.Errorf("%d album lookups, want 1 for the album link only", n)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+
+	// apiKeysFileEnv names a file holding extra API keys, one per line (e.g. a Docker secret).
+	apiKeysFileEnv = "IMMICH_PROXY_API_KEYS_FILE"
+)
+
+// resolveSecretList resolves a secret reference:
+//
+//	env:NAME   value of $NAME, or the contents of the file named by $NAME_FILE
+//	file:PATH  contents of PATH
+//	anything else is taken literally
+//
+// A variable or file holding one value per line yields one value per line.
+func resolveSecretList(ref string) ([]string, error) {
+	ref = strings.TrimSpace(ref)
+	switch {
+	case strings.HasPrefix(ref, secretEnvPrefix):
+		name := strings.TrimPrefix(ref, secretEnvPrefix)
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			return splitSecretLines(v), nil
+		}
+		if path, ok := os.LookupEnv(name + "_FILE"); ok && path != "" {
+			return readSecretFile(path)
+		}
+		return nil, fmt.Errorf("secret %s: neither %s nor %s_FILE is set", describeSecretRef(ref), name, name)
+	case strings.HasPrefix(ref, secretFilePrefix):
+		return readSecretFile(strings.TrimPrefix(ref, secretFilePrefix))
+	case ref == "":
+		return nil, fmt.Errorf("empty secret")
+	default:
+		return []string{ref}, nil
+	}
+}
+
+// resolveSecret resolves a reference that must hold exactly one value.
+func resolveSecret(ref string) (string, error) {
+	values, err := resolveSecretList(ref)
+	if err != nil {
+		return "", err
+	}
+	if len(values) != 1 {
+		return "", fmt.Errorf("secret %s holds %d values, expected one", describeSecretRef(ref), len(values))
+	}
+	return values[0], nil
+}
+
+func readSecretFile(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read secret file: %w", err)
+	}
+	values := splitSecretLines(string(b))
+	if len(values) == 0 {
+		return nil, fmt.Errorf("secret file %s is empty", path)
+	}
+	return values, nil
+}
+
+// splitSecretLines splits on newlines, dropping blank lines and # comments.
+func splitSecretLines(s string) []string {
+	var values []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		values = append(values, line)
+	}
+	return values
+}
+
+// describeSecretRef returns a reference that is safe to print: literal
+// secrets are reduced to their fingerprint.
+func describeSecretRef(ref string) string {
+	if strings.HasPrefix(ref, secretEnvPrefix) || strings.HasPrefix(ref, secretFilePrefix) {
+		return ref
+	}
+	return redactKey(ref)
+}
+
+// resolveAPIKeys expands every reference in refs, plus the file named by
+// IMMICH_PROXY_API_KEYS_FILE, into a de-duplicated list of keys.
+func resolveAPIKeys(refs []string) ([]string, error) {
+	var keys []string
+	seen := make(map[string]bool)
+	add := func(values []string) {
+		for _, v := range values {
+			if !seen[v] {
+				seen[v] = true
+				keys = append(keys, v)
+			}
+		}
+	}
+	for i, ref := range refs {
+		values, err := resolveSecretList(ref)
+		if err != nil {
+			return nil, fmt.Errorf("immich.api_keys[%d]: %w", i, err)
+		}
+		add(values)
+	}
+	if path := os.Getenv(apiKeysFileEnv); path != "" {
+		values, err := readSecretFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", apiKeysFileEnv, err)
+		}
+		add(values)
+	}
+	return keys, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeSecretFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResolveSecretList(t *testing.T) {
+	keysFile := writeSecretFile(t, "# proxy keys\nkey-a\n\n  key-b  \n# old: key-c\n")
+	emptyFile := writeSecretFile(t, "# nothing yet\n\n")
+	t.Setenv("TEST_SECRET", "key-env")
+	t.Setenv("TEST_SECRET_LINES", "key-1\nkey-2\n")
+	t.Setenv("TEST_SECRET_VIA_FILE_FILE", keysFile)
+	t.Setenv("TEST_SECRET_PREFERRED", "key-env")
+	t.Setenv("TEST_SECRET_PREFERRED_FILE", keysFile)
+
+	tests := []struct {
+		ref     string
+		want    []string
+		wantErr string
+	}{
+		{ref: "literal-key", want: []string{"literal-key"}},
+		{ref: "  literal-key \n", want: []string{"literal-key"}},
+		{ref: "env:TEST_SECRET", want: []string{"key-env"}},
+		{ref: "env:TEST_SECRET_LINES", want: []string{"key-1", "key-2"}},
+		{ref: "env:TEST_SECRET_VIA_FILE", want: []string{"key-a", "key-b"}},
+		{ref: "env:TEST_SECRET_PREFERRED", want: []string{"key-env"}},
+		{ref: "env:TEST_SECRET_UNSET", wantErr: "neither TEST_SECRET_UNSET nor TEST_SECRET_UNSET_FILE is set"},
+		{ref: "file:" + keysFile, want: []string{"key-a", "key-b"}},
+		{ref: "file:" + emptyFile, wantErr: "is empty"},
+		{ref: "file:" + filepath.Join(t.TempDir(), "missing"), wantErr: "read secret file"},
+		{ref: "", wantErr: "empty secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := resolveSecretList(tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveSecretList(%q) error = %v, want %q", tt.ref, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveSecretList(%q) error = %v", tt.ref, err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("resolveSecretList(%q) = %v, want %v", tt.ref, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveAPIKeys(t *testing.T) {
+	keysFile := writeSecretFile(t, "key-a\nkey-b\n")
+	extraFile := writeSecretFile(t, "# docker secret\nkey-b\nkey-c\n")
+	emptyFile := writeSecretFile(t, "\n")
+	t.Setenv("TEST_API_KEY", "key-a")
+
+	tests := []struct {
+		name     string
+		refs     []string
+		keysFile string // IMMICH_PROXY_API_KEYS_FILE
+		want     []string
+		wantErr  string
+	}{
+		{
+			name: "duplicates are dropped, first occurrence kept",
+			refs: []string{"key-a", "env:TEST_API_KEY", "file:" + keysFile, "key-a"},
+			want: []string{"key-a", "key-b"},
+		},
+		{
+			name:     "keys file is appended",
+			refs:     []string{"file:" + keysFile},
+			keysFile: extraFile,
+			want:     []string{"key-a", "key-b", "key-c"},
+		},
+		{name: "keys file alone", keysFile: extraFile, want: []string{"key-b", "key-c"}},
+		{
+			name:    "bad reference names its index",
+			refs:    []string{"key-a", "file:" + emptyFile},
+			wantErr: "immich.api_keys[1]: secret file",
+		},
+		{name: "empty keys file", refs: []string{"key-a"}, keysFile: emptyFile, wantErr: apiKeysFileEnv},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(apiKeysFileEnv, tt.keysFile)
+			got, err := resolveAPIKeys(tt.refs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("resolveAPIKeys() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveAPIKeys() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("resolveAPIKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
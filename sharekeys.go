@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const shareKeyCacheTTL = 5 * time.Minute
+
+// shareKeyCache maps share keys to the album they share, so asset requests
+// can be checked against the album rules without asking Immich every time.
+// Share keys are held by fingerprint only.
+type shareKeyCache struct {
+	client *IMMICHClient
+
+	lock    sync.Mutex // to protect entries
+	entries map[string]sharedAlbum
+}
+
+// sharedAlbum is the album behind a share key; empty for links to single
+// assets. The flags are those of the shared link.
+type sharedAlbum struct {
+	AlbumID       string
+	AlbumName     string
+	AllowDownload bool
+	ShowMetadata  bool
+	expires       time.Time
+}
+
+func newShareKeyCache(client *IMMICHClient) *shareKeyCache {
+	return &shareKeyCache{client: client, entries: make(map[string]sharedAlbum)}
+}
+
+// Album returns the album shared by shareKey. Failed lookups are not cached;
+// while Immich cannot be reached, expired entries are served for staleTTL.
+func (c *shareKeyCache) Album(ctx context.Context, shareKey string) (sharedAlbum, error) {
+	id := hashKey(shareKey)
+	c.lock.Lock()
+	entry, ok := c.entries[id]
+	c.lock.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry, nil
+	}
+
+	info, err := c.client.GetSharedLinksInfo(ctx, shareKey)
+	if ok && upstreamUnavailable(err) {
+		requestLogger(ctx).Warnf("Serving share key from its expired cache entry: %v", err)
+		return entry, nil
+	}
+	if err != nil {
+		return sharedAlbum{}, err
+	}
+	entry = sharedAlbum{
+		AllowDownload: info.AllowDownload != nil && *info.AllowDownload,
+		ShowMetadata:  info.ShowMetadata != nil && *info.ShowMetadata,
+		expires:       time.Now().Add(shareKeyCacheTTL),
+	}
+	if info.Album != nil {
+		entry.AlbumID, entry.AlbumName = info.Album.ID, info.Album.AlbumName
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for k, e := range c.entries {
+		if time.Since(e.expires) > staleTTL {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[id] = entry
+	return entry, nil
+}
+
+// Name implements Purger.
+func (c *shareKeyCache) Name() string {
+	return "shareKeys"
+}
+
+func (c *shareKeyCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.entries)
+}
+
+// PurgeAlbum drops the share keys of albumID.
+func (c *shareKeyCache) PurgeAlbum(albumID string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	n := 0
+	for k, e := range c.entries {
+		if e.AlbumID == albumID {
+			delete(c.entries, k)
+			n++
+		}
+	}
+	return n
+}
+
+// PurgeAsset implements Purger; assets are not cached here.
+func (c *shareKeyCache) PurgeAsset(string) int {
+	return 0
+}
+
+// sharedAlbumAccess checks that the share key of r shares albumID and that
+// the album rules allow it, and answers the request with an error if not.
+// Keys Immich does not know and keys of other albums both get a 404, so
+// album IDs cannot be probed.
+func (s *ImmichService) sharedAlbumAccess(w http.ResponseWriter, r *http.Request, albumID string) (sharedAlbum, bool) {
+	logger := requestLogger(r.Context())
+	shareKey := GetShareKey(r)
+	if shareKey == "" {
+		http.Error(w, "Missing share key", http.StatusBadRequest)
+		return sharedAlbum{}, false
+	}
+	album, err := s.shareKeys.Album(r.Context(), shareKey)
+	if hasStatus(err, http.StatusUnauthorized) || (err == nil && album.AlbumID != albumID) {
+		logger.Debugf("Share key does not share album %s", albumID)
+		http.Error(w, "Album not found", http.StatusNotFound)
+		return sharedAlbum{}, false
+	}
+	if err != nil {
+		logger.Errorf("Failed to look up the album of share key: %v", err)
+		http.Error(w, "Failed to check album access", http.StatusBadGateway)
+		return sharedAlbum{}, false
+	}
+	if !s.client.AlbumsKeys.AlbumAllowed(r.Context(), album.AlbumID, album.AlbumName) {
+		logger.Infof("Refusing album %s: not allowed by the album rules", albumID)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return sharedAlbum{}, false
+	}
+	return album, true
+}
+
+// RequireAllowedAlbum refuses asset requests whose share key belongs to an
+// album the album rules refuse. Without rules every request passes, and so
+// do links to single assets: they share no album for the rules to judge.
+func (s *ImmichService) RequireAllowedAlbum(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		albums := s.client.AlbumsKeys
+		shareKey := GetShareKey(r)
+		if !albums.HasAlbumRules() || shareKey == "" {
+			next(w, r)
+			return
+		}
+		logger := requestLogger(r.Context())
+		album, err := s.shareKeys.Album(r.Context(), shareKey)
+		if err != nil {
+			logger.Errorf("Failed to look up the album of share key: %v", err)
+			http.Error(w, "Failed to check album access", http.StatusBadGateway)
+			return
+		}
+		if album.AlbumID == "" {
+			logger.Debugf("Share key links to single assets, album rules do not apply")
+			next(w, r)
+			return
+		}
+		if !albums.AlbumAllowed(r.Context(), album.AlbumID, album.AlbumName) {
+			logger.Infof("Refusing asset %s of album %q: not allowed by the album rules", GetAssetID(r), album.AlbumID)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
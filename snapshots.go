@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// staleTTL is how long cached answers stand in for Immich while it
+	// cannot be reached.
+	staleTTL = 24 * time.Hour
+
+	maxAlbumSnapshots = 100
+)
+
+// albumSnapshots keeps the last album info with assets fetched per album,
+// so frames and pages keep working while Immich is briefly unreachable.
+type albumSnapshots struct {
+	lock    sync.Mutex // to protect entries
+	entries map[string]albumSnapshot
+}
+
+type albumSnapshot struct {
+	album   AlbumInfo
+	fetched time.Time
+}
+
+func newAlbumSnapshots() *albumSnapshots {
+	return &albumSnapshots{entries: make(map[string]albumSnapshot)}
+}
+
+func (c *albumSnapshots) get(albumID string) (AlbumInfo, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[albumID]
+	if !ok || time.Since(entry.fetched) > staleTTL {
+		return AlbumInfo{}, false
+	}
+	return entry.album, true
+}
+
+// put stores album, evicting the oldest snapshot when full.
+func (c *albumSnapshots) put(album AlbumInfo) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.entries[album.ID]; !ok && len(c.entries) >= maxAlbumSnapshots {
+		oldest := ""
+		for id, e := range c.entries {
+			if oldest == "" || e.fetched.Before(c.entries[oldest].fetched) {
+				oldest = id
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[album.ID] = albumSnapshot{album: album, fetched: time.Now()}
+}
+
+// Name implements Purger.
+func (c *albumSnapshots) Name() string {
+	return "albumSnapshots"
+}
+
+func (c *albumSnapshots) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.entries)
+}
+
+func (c *albumSnapshots) PurgeAlbum(albumID string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.entries[albumID]; !ok {
+		return 0
+	}
+	delete(c.entries, albumID)
+	return 1
+}
+
+// PurgeAsset implements Purger; snapshots are dropped per album only.
+func (c *albumSnapshots) PurgeAsset(string) int {
+	return 0
+}
+
+// albumAssets returns the album with its assets. When Immich cannot be
+// reached it returns the last snapshot of the album instead, with stale set.
+func (s *ImmichService) albumAssets(ctx context.Context, albumID string) (album AlbumInfo, stale bool, err error) {
+	album, err = s.client.GetAlbumInfo(ctx, albumID, false)
+	if err == nil {
+		s.snapshots.put(album)
+		return album, false, nil
+	}
+	if upstreamUnavailable(err) {
+		if snapshot, ok := s.snapshots.get(albumID); ok {
+			requestLogger(ctx).Warnf("Serving album %s from its snapshot: %v", albumID, err)
+			return snapshot, true, nil
+		}
+	}
+	return AlbumInfo{}, false, err
+}
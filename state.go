@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const stateVersion = 1
+
+// albumsState is the on-disk form of the album->key map and album metadata.
+// Keys are stored as fingerprints only and matched against the configured
+// keys on load.
+type albumsState struct {
+	Version int               `json:"version"`
+	SavedAt time.Time         `json:"savedAt"`
+	Albums  []albumStateEntry `json:"albums"`
+}
+
+type albumStateEntry struct {
+	AlbumID  string    `json:"albumId"`
+	Backend  string    `json:"backend"` // Immich URL the album was found on
+	Key      string    `json:"key"`     // fingerprint of the owning API key
+	LastSeen time.Time `json:"lastSeen"`
+	AlbumMeta
+}
+
+// EnableState loads the album map saved in path, if any, and saves it there
+// after every sync and on SaveState. Entries for another Immich URL, for a
+// key that is no longer configured or refused by the album rules are
+// skipped. The next sync reconciles the restored entries like any others.
+func (a *AlbumsKeys) EnableState(path string) error {
+	a.lock.Lock()
+	a.statePath = path
+	a.lock.Unlock()
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Infof("No album state in %s yet, starting empty", path)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read album state: %w", err)
+	}
+	var state albumsState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return fmt.Errorf("decode album state %s: %w", path, err)
+	}
+	if state.Version != stateVersion {
+		log.Warnf("Ignoring album state in %s: version %d, expected %d", path, state.Version, stateVersion)
+		return nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	keys := make(map[string]string, len(a.ApiKeys))
+	for _, key := range a.ApiKeys {
+		keys[hashKey(key)] = key
+	}
+	restored, skipped := 0, 0
+	for _, e := range state.Albums {
+		key, ok := keys[e.Key]
+		if !ok || e.Backend != a.immageBaseURL {
+			skipped++
+			continue
+		}
+		if !a.policy.Allowed(key, e.AlbumID, e.Name) {
+			a.refused[e.AlbumID] = refusedAlbum{key: key, name: e.Name, seen: e.LastSeen}
+			skipped++
+			continue
+		}
+		a.AlbumsKeys[e.AlbumID] = key
+		a.lastSeen[e.AlbumID] = e.LastSeen
+		a.meta[e.AlbumID] = e.AlbumMeta
+		restored++
+	}
+	log.Infof("Restored %d album(s) from %s saved %s ago, skipped %d",
+		restored, path, time.Since(state.SavedAt).Truncate(time.Second), skipped)
+	return nil
+}
+
+// SaveState writes the album map to the state file, if enabled. The file is
+// replaced atomically so a crash never leaves it half-written.
+func (a *AlbumsKeys) SaveState() error {
+	a.lock.Lock()
+	path := a.statePath
+	state := albumsState{Version: stateVersion, SavedAt: time.Now().UTC()}
+	for albumId, key := range a.AlbumsKeys {
+		state.Albums = append(state.Albums, albumStateEntry{
+			AlbumID:   albumId,
+			Backend:   a.immageBaseURL,
+			Key:       hashKey(key),
+			LastSeen:  a.lastSeen[albumId].UTC(),
+			AlbumMeta: a.meta[albumId],
+		})
+	}
+	a.lock.Unlock()
+	if path == "" {
+		return nil
+	}
+	slices.SortFunc(state.Albums, func(x, y albumStateEntry) int { return strings.Compare(x.AlbumID, y.AlbumID) })
+
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode album state: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create album state: %w", err)
+	}
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("write album state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("close album state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("replace album state: %w", err)
+	}
+	log.Debugf("Saved %d album(s) to %s", len(state.Albums), path)
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	const key1, key2, key3 = "key-one-secret", "key-two-secret", "key-three-secret"
+	const immichURL = "http://immich.invalid"
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	a := NewAlbumsKeys([]string{key1, key2, key3}, true, immichURL)
+	if err := a.EnableState(path); err != nil {
+		t.Fatalf("EnableState() without a file = %v", err)
+	}
+	a.reconcile(map[string][]AlbumInfo{
+		key1: {{ID: "a1", AlbumName: "Holiday", AssetCount: 2}},
+		key2: {{ID: "a2", AlbumName: "Family", AssetCount: 1, HasSharedLink: true}},
+		key3: {{ID: "a3", AlbumName: "Private"}},
+	}, map[string][]string{"a2": {"x"}})
+
+	// a reader of the previous file keeps seeing it whole while it is replaced
+	if err := os.WriteFile(path, []byte(`{"version":1,"albums":[]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer previous.Close()
+	if err := a.SaveState(); err != nil {
+		t.Fatalf("SaveState() = %v", err)
+	}
+	if b, _ := io.ReadAll(previous); string(b) != `{"version":1,"albums":[]}` {
+		t.Errorf("previous file was overwritten in place: %s", b)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("state directory holds %d files, want the state file only", len(entries))
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{key1, key2, key3} {
+		if strings.Contains(string(b), key) {
+			t.Errorf("state file holds the raw key %q", key)
+		}
+		if !strings.Contains(string(b), hashKey(key)) {
+			t.Errorf("state file lacks the fingerprint of %q", key)
+		}
+	}
+
+	// key1 is no longer configured, key3 may no longer serve Private
+	restored := NewAlbumsKeys([]string{key2, key3}, true, immichURL)
+	restored.SetAlbumRules([]AlbumRule{{Key: hashKey(key3), Deny: []string{"private"}}})
+	if err := restored.EnableState(path); err != nil {
+		t.Fatalf("EnableState() = %v", err)
+	}
+	if got, want := restored.Albums(), map[string]string{"a2": key2}; !maps.Equal(got, want) {
+		t.Errorf("restored albums = %v, want %v", got, want)
+	}
+	meta, _ := restored.AlbumMeta("a2")
+	if meta.Name != "Family" || !meta.HasSharedLink || meta.Assets == nil || !slices.Equal(meta.Assets.IDs, []string{"x"}) {
+		t.Errorf("restored meta of a2 = %+v", meta)
+	}
+
+	// entries of another Immich are skipped
+	other := NewAlbumsKeys([]string{key1, key2, key3}, true, "http://other.invalid")
+	if err := other.EnableState(path); err != nil {
+		t.Fatalf("EnableState() = %v", err)
+	}
+	if n := other.Len(); n != 0 {
+		t.Errorf("%d albums restored for another Immich URL, want none", n)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"cmp"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxTrackedAlbums bounds the per-album traffic table so requests for made-up
+// album IDs cannot grow it without limit.
+const maxTrackedAlbums = 10000
+
+// Stats tracks live connection and request figures of the public listener for
+// the admin API.
+type Stats struct {
+	started  time.Time
+	accepted atomic.Int64
+	requests atomic.Int64
+	inFlight atomic.Int64
+
+	lock   sync.Mutex // to protect conns and albums
+	conns  map[net.Conn]http.ConnState
+	albums map[string]*AlbumTraffic
+}
+
+// ConnectionStats is a snapshot of Stats.
+type ConnectionStats struct {
+	Open             int     `json:"open"`
+	Active           int     `json:"active"`
+	Idle             int     `json:"idle"`
+	AcceptedTotal    int64   `json:"acceptedTotal"`
+	InFlightRequests int64   `json:"inFlightRequests"`
+	RequestsTotal    int64   `json:"requestsTotal"`
+	UptimeSeconds    float64 `json:"uptimeSeconds"`
+}
+
+// AlbumTraffic is the successful traffic served for one album.
+type AlbumTraffic struct {
+	AlbumID  string `json:"albumId"`
+	Requests int64  `json:"requests"`
+	Bytes    int64  `json:"bytes"`
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		started: time.Now(),
+		conns:   make(map[net.Conn]http.ConnState),
+		albums:  make(map[string]*AlbumTraffic),
+	}
+}
+
+// ConnState is installed as http.Server.ConnState.
+func (s *Stats) ConnState(c net.Conn, state http.ConnState) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch state {
+	case http.StateNew:
+		s.accepted.Add(1)
+		s.conns[c] = state
+	case http.StateActive, http.StateIdle:
+		s.conns[c] = state
+	case http.StateHijacked, http.StateClosed:
+		delete(s.conns, c)
+	}
+}
+
+func (s *Stats) Connections() ConnectionStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cs := ConnectionStats{
+		Open:             len(s.conns),
+		AcceptedTotal:    s.accepted.Load(),
+		InFlightRequests: s.inFlight.Load(),
+		RequestsTotal:    s.requests.Load(),
+		UptimeSeconds:    time.Since(s.started).Seconds(),
+	}
+	for _, state := range s.conns {
+		switch state {
+		case http.StateActive:
+			cs.Active++
+		case http.StateIdle:
+			cs.Idle++
+		}
+	}
+	return cs
+}
+
+// Middleware counts requests and records the traffic of album routes. A nil
+// Stats passes requests through.
+func (s *Stats) Middleware(next http.Handler) http.Handler {
+	if s == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := mux.CurrentRoute(r)
+		if route == nil || rec.status >= 400 {
+			return
+		}
+		if tpl, err := route.GetPathTemplate(); err == nil && isAlbumRoute(tpl) {
+			s.recordAlbum(mux.Vars(r)["id"], rec.bytes)
+		}
+	})
+}
+
+// isAlbumRoute reports whether the route template serves one album whose
+// ID is the id variable.
+func isAlbumRoute(tpl string) bool {
+	for _, prefix := range []string{"/api/albums/", "/feeds/albums/", "/frame/", "/g/"} {
+		if strings.HasPrefix(tpl, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Stats) recordAlbum(albumID string, bytes int64) {
+	if albumID == "" {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	t, ok := s.albums[albumID]
+	if !ok {
+		if len(s.albums) >= maxTrackedAlbums {
+			return
+		}
+		t = &AlbumTraffic{AlbumID: albumID}
+		s.albums[albumID] = t
+	}
+	t.Requests++
+	t.Bytes += bytes
+}
+
+// TopAlbums returns up to n albums with the most requests.
+func (s *Stats) TopAlbums(n int) []AlbumTraffic {
+	s.lock.Lock()
+	top := make([]AlbumTraffic, 0, len(s.albums))
+	for _, t := range s.albums {
+		top = append(top, *t)
+	}
+	s.lock.Unlock()
+	slices.SortFunc(top, func(a, b AlbumTraffic) int {
+		if c := cmp.Compare(b.Requests, a.Requests); c != 0 {
+			return c
+		}
+		return strings.Compare(a.AlbumID, b.AlbumID)
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const certPollInterval = 10 * time.Second
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// certReloader serves the certificate in certFile/keyFile and reloads it when
+// either file changes, e.g. after a renewal by certbot or acme.sh.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	lock     sync.RWMutex // to protect cert
+	cert     *tls.Certificate
+	certInfo os.FileInfo
+	keyInfo  os.FileInfo
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) load() error {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return fmt.Errorf("stat certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return fmt.Errorf("stat key: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair: %w", err)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.cert = &cert
+	c.certInfo = certInfo
+	c.keyInfo = keyInfo
+	return nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.cert, nil
+}
+
+func (c *certReloader) changed() bool {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return false
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return fileChanged(c.certInfo, certInfo) || fileChanged(c.keyInfo, keyInfo)
+}
+
+// Watch polls the certificate files until ctx is done. A pair that fails to
+// load, e.g. because only one file has been replaced so far, is retried on
+// the next poll while the old certificate keeps being served.
+func (c *certReloader) Watch(ctx context.Context) {
+	ticker := time.NewTicker(certPollInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !c.changed() {
+					continue
+				}
+				if err := c.load(); err != nil {
+					log.Warnf("TLS certificate changed but failed to load, keeping the current one: %v", err)
+					continue
+				}
+				log.Infof("Reloaded TLS certificate from %s", c.certFile)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// newTLSConfig builds the server TLS config from cfg, serving certificates from certs.
+func newTLSConfig(cfg TLSConfig, certs *certReloader) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if cfg.MinVersion != "" {
+		v, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported minVersion %q", cfg.MinVersion)
+		}
+		tlsConfig.MinVersion = v
+	}
+	if len(cfg.CipherSuites) > 0 {
+		ids, err := cipherSuiteIDs(cfg.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.CipherSuites = ids
+	}
+	if cfg.DisableHTTP2 {
+		tlsConfig.NextProtos = []string{"http/1.1"}
+	} else {
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return tlsConfig, nil
+}
+
+// cipherSuiteIDs maps crypto/tls suite names to IDs, rejecting insecure
+// suites. They only apply to TLS 1.2; TLS 1.3 suites are not configurable.
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// configureTLS sets up srv to serve TLS per cfg and starts watching the
+// certificate files.
+func configureTLS(ctx context.Context, srv *http.Server, cfg TLSConfig) error {
+	certs, err := newCertReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := newTLSConfig(cfg, certs)
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = tlsConfig
+	if cfg.DisableHTTP2 {
+		// a non-nil empty map keeps net/http from enabling HTTP/2
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	certs.Watch(ctx)
+	return nil
+}
+
+// newRedirectServer answers plain HTTP on addr with a permanent redirect to
+// the HTTPS listener on tlsListen.
+func newRedirectServer(addr, tlsListen string) *http.Server {
+	_, port, _ := net.SplitHostPort(tlsListen)
+	return &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: readHeaderTimeout,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			if port != "" && port != "443" {
+				host = net.JoinHostPort(host, port)
+			}
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+		}),
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingMiddlewareIDs(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	r := mux.NewRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	for _, path := range []string{
+		`/api/albums/{id:[^/]+}`,
+		`/api/assets/{id:[^/]+}/thumbnail`,
+		`/feeds/albums/{id:[^/]+}.atom`,
+		`/frame/{id:[^/]+}`,
+		`/g/{id:[^/]+}`,
+		`/healthz`,
+	} {
+		r.HandleFunc(path, ok)
+	}
+	r.Use(tracingMiddleware)
+
+	tests := []struct {
+		path         string
+		album, asset string
+	}{
+		{path: "/api/albums/a1", album: "a1"},
+		{path: "/api/assets/as1/thumbnail", asset: "as1"},
+		{path: "/feeds/albums/a2.atom", album: "a2"},
+		{path: "/frame/a3", album: "a3"},
+		{path: "/g/a4", album: "a4"},
+		{path: "/healthz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			before := len(spans.Ended())
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+			ended := spans.Ended()
+			if len(ended) != before+1 {
+				t.Fatalf("%d spans ended, want 1", len(ended)-before)
+			}
+			attrs := make(map[string]string)
+			for _, kv := range ended[len(ended)-1].Attributes() {
+				attrs[string(kv.Key)] = kv.Value.Emit()
+			}
+			if attrs["immich.album_id"] != tt.album || attrs["immich.asset_id"] != tt.asset {
+				t.Errorf("album_id %q and asset_id %q, want %q and %q",
+					attrs["immich.album_id"], attrs["immich.asset_id"], tt.album, tt.asset)
+			}
+		})
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultListen                = ":8080"
+	defaultLogLevel              = "info"
+	defaultLogFormat             = "text"
+	defaultAlbumsRefreshInterval = "5m"
+	defaultTracingExporter       = "otlp"
+	defaultShutdownTimeout       = "30s"
+	minAdminTokenLength          = 16
+	defaultMissCacheTTL          = time.Minute
+	defaultAlbumListCacheTTL     = 10 * time.Second
+	defaultKeyCheckInterval      = 5 * time.Minute
+)
+
+// ValidationError is a problem with a single config field.
+type ValidationError struct {
+	Path    string // YAML path, e.g. immich.url
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors collects every problem found by Config.Validate.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = "  " + err.Error()
+	}
+	return fmt.Sprintf("%d config error(s):\n%s", len(e), strings.Join(lines, "\n"))
+}
+
+func (e *ValidationErrors) add(path, format string, args ...interface{}) {
+	*e = append(*e, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// applyDefaults fills in every unset field that has a sensible default.
+func (c *Config) applyDefaults() {
+	if c.Listen == "" {
+		c.Listen = defaultListen
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = defaultLogLevel
+	}
+	if c.LogFormat == "" {
+		c.LogFormat = defaultLogFormat
+	}
+	if c.Immich.AlbumsRefreshInterval == "" {
+		c.Immich.AlbumsRefreshInterval = defaultAlbumsRefreshInterval
+	}
+	if len(c.Immich.SyncScope) == 0 {
+		c.Immich.SyncScope = []string{scopeOwned}
+	}
+	if c.Immich.MissCacheTTL == "" {
+		c.Immich.MissCacheTTL = defaultMissCacheTTL.String()
+	}
+	if c.Immich.AlbumListCacheTTL == "" {
+		c.Immich.AlbumListCacheTTL = defaultAlbumListCacheTTL.String()
+	}
+	if c.Immich.KeyCheckInterval == "" {
+		c.Immich.KeyCheckInterval = defaultKeyCheckInterval.String()
+	}
+	c.Immich.URL = strings.TrimRight(c.Immich.URL, "/")
+	c.PublicURL = strings.TrimRight(c.PublicURL, "/")
+	if c.Audit.MaxSizeMB == 0 {
+		c.Audit.MaxSizeMB = defaultAuditMaxSizeMB
+	}
+	if c.Audit.MaxBackups == 0 {
+		c.Audit.MaxBackups = defaultAuditMaxBackups
+	}
+	if c.Webhooks.MaxAttempts == 0 {
+		c.Webhooks.MaxAttempts = defaultWebhookMaxAttempts
+	}
+	if c.Webhooks.Timeout == "" {
+		c.Webhooks.Timeout = defaultWebhookTimeout.String()
+	}
+	if c.ShutdownTimeout == "" {
+		c.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if c.Tracing.Exporter == "" {
+		c.Tracing.Exporter = defaultTracingExporter
+	}
+	if c.Tracing.ServiceName == "" {
+		c.Tracing.ServiceName = defaultTracingServiceName
+	}
+}
+
+// Validate checks the whole config and returns every problem at once as
+// ValidationErrors, or nil.
+func (c *Config) Validate() error {
+	var errs ValidationErrors
+
+	validateURL(&errs, "immich.url", c.Immich.URL, true)
+	if len(c.Immich.APIKeys) == 0 && len(c.Immich.Logins) == 0 {
+		errs.add("immich.api_keys", "at least one API key or login is required")
+	}
+	emails := make(map[string]bool)
+	for i, l := range c.Immich.Logins {
+		p := fmt.Sprintf("immich.logins[%d]", i)
+		switch {
+		case l.Email == "":
+			errs.add(p+".email", "is required")
+		case emails[strings.ToLower(l.Email)]:
+			errs.add(p+".email", "duplicate login %q", l.Email)
+		}
+		emails[strings.ToLower(l.Email)] = true
+		if l.Password == "" {
+			errs.add(p+".password", "is required")
+		}
+	}
+	if d, err := time.ParseDuration(c.Immich.AlbumsRefreshInterval); err != nil {
+		errs.add("immich.albumsRefreshInterval", "invalid duration %q, expected e.g. 5m or 1h", c.Immich.AlbumsRefreshInterval)
+	} else if d <= 0 {
+		errs.add("immich.albumsRefreshInterval", "must be positive")
+	}
+	for i, scope := range c.Immich.SyncScope {
+		if scope != scopeOwned && scope != scopeShared {
+			errs.add(fmt.Sprintf("immich.syncScope[%d]", i), "invalid scope %q, expected owned or shared", scope)
+		}
+	}
+	for path, v := range map[string]string{
+		"immich.missCacheTTL":      c.Immich.MissCacheTTL,
+		"immich.albumListCacheTTL": c.Immich.AlbumListCacheTTL,
+		"immich.keyCheckInterval":  c.Immich.KeyCheckInterval,
+	} {
+		if d, err := time.ParseDuration(v); err != nil {
+			errs.add(path, "invalid duration %q, expected e.g. 30s, 0 disables", v)
+		} else if d < 0 {
+			errs.add(path, "must not be negative")
+		}
+	}
+
+	validateAlbumRules(&errs, c.AlbumRules)
+	validateURL(&errs, "publicURL", c.PublicURL, false)
+
+	validateListen(&errs, "listen", c.Listen)
+	if d, err := time.ParseDuration(c.ShutdownTimeout); err != nil {
+		errs.add("shutdownTimeout", "invalid duration %q, expected e.g. 30s", c.ShutdownTimeout)
+	} else if d < 0 {
+		errs.add("shutdownTimeout", "must not be negative")
+	}
+	validateAdmin(&errs, &c.Admin, c.Listen)
+
+	validateTLS(&errs, &c.TLS, c.Listen)
+
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		errs.add("logLevel", "invalid level %q, expected one of debug, info, warn, error", c.LogLevel)
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs.add("logFormat", "invalid format %q, expected text or json", c.LogFormat)
+	}
+
+	validateCORS(&errs, &c.Cors)
+
+	for i, cidr := range c.TrustedProxies {
+		if _, err := parseCIDRs([]string{cidr}); err != nil {
+			errs.add(fmt.Sprintf("trustedProxies[%d]", i), "invalid CIDR %q", cidr)
+		}
+	}
+
+	if c.StateFile != "" {
+		validateParentDir(&errs, "stateFile", c.StateFile)
+	}
+	validateWebhooks(&errs, &c.Webhooks, c.Immich.AlbumsSyncEnabled)
+
+	if c.Audit.Enabled && c.Audit.Path == "" {
+		errs.add("audit.path", "required when audit.enabled is true")
+	}
+	if c.Audit.MaxSizeMB < 0 {
+		errs.add("audit.maxSizeMB", "must not be negative")
+	}
+	if c.Audit.MaxBackups < 0 {
+		errs.add("audit.maxBackups", "must not be negative")
+	}
+
+	if c.Tracing.Exporter != "otlp" && c.Tracing.Exporter != "stdout" {
+		errs.add("tracing.exporter", "invalid exporter %q, expected otlp or stdout", c.Tracing.Exporter)
+	}
+	if c.Tracing.Endpoint != "" {
+		validateURL(&errs, "tracing.endpoint", c.Tracing.Endpoint, false)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs.add("tracing.sampleRatio", "must be above 0 and at most 1, or unset to sample every trace")
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateParentDir checks that the directory file is to be written in exists.
+func validateParentDir(errs *ValidationErrors, path, file string) {
+	if info, err := os.Stat(filepath.Dir(file)); err != nil {
+		errs.add(path, "%v", err)
+	} else if !info.IsDir() {
+		errs.add(path, "%s is not a directory", filepath.Dir(file))
+	}
+}
+
+func validateWebhooks(errs *ValidationErrors, w *WebhookConfig, syncEnabled bool) {
+	if !w.Enabled() {
+		return
+	}
+	for i, u := range w.URLs {
+		validateURL(errs, fmt.Sprintf("webhooks.urls[%d]", i), u, true)
+	}
+	if w.Secret == "" {
+		errs.add("webhooks.secret", "required to sign events")
+	}
+	if !syncEnabled {
+		errs.add("webhooks.urls", "requires immich.albumsSyncEnabled, changes are found by the sync")
+	}
+	for i, pattern := range w.Albums {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs.add(fmt.Sprintf("webhooks.albums[%d]", i), "invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if w.MaxAttempts < 1 {
+		errs.add("webhooks.maxAttempts", "must be at least 1")
+	}
+	if d, err := time.ParseDuration(w.Timeout); err != nil {
+		errs.add("webhooks.timeout", "invalid duration %q, expected e.g. 10s", w.Timeout)
+	} else if d <= 0 {
+		errs.add("webhooks.timeout", "must be positive")
+	}
+	if w.DeadLetterFile != "" {
+		validateParentDir(errs, "webhooks.deadLetterFile", w.DeadLetterFile)
+	}
+}
+
+func validateURL(errs *ValidationErrors, path, raw string, required bool) {
+	if raw == "" {
+		if required {
+			errs.add(path, "required")
+		}
+		return
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		errs.add(path, "invalid URL: %v", err)
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		errs.add(path, "scheme must be http or https, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		errs.add(path, "missing host in %q", raw)
+	}
+}
+
+func validateListen(errs *ValidationErrors, path, addr string) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		errs.add(path, "invalid address %q, expected host:port or :port", addr)
+		return
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		errs.add(path, "invalid port %q", port)
+	}
+}
+
+func validateTLS(errs *ValidationErrors, t *TLSConfig, listen string) {
+	if !t.Enabled() {
+		if t.RedirectListen != "" {
+			errs.add("tls.redirectListen", "requires tls.certFile and tls.keyFile")
+		}
+		return
+	}
+	for path, file := range map[string]string{"tls.certFile": t.CertFile, "tls.keyFile": t.KeyFile} {
+		if file == "" {
+			errs.add(path, "required when TLS is enabled")
+		} else if _, err := os.Stat(file); err != nil {
+			errs.add(path, "%v", err)
+		}
+	}
+	if t.MinVersion != "" {
+		if _, ok := tlsVersions[t.MinVersion]; !ok {
+			errs.add("tls.minVersion", "unsupported version %q, expected 1.2 or 1.3", t.MinVersion)
+		}
+	}
+	if _, err := cipherSuiteIDs(t.CipherSuites); err != nil {
+		errs.add("tls.cipherSuites", "%v", err)
+	}
+	if t.RedirectListen != "" {
+		validateListen(errs, "tls.redirectListen", t.RedirectListen)
+		if t.RedirectListen == listen {
+			errs.add("tls.redirectListen", "must differ from listen")
+		}
+	}
+}
+
+func validateAdmin(errs *ValidationErrors, a *AdminConfig, listen string) {
+	if a.Listen == "" {
+		if a.Token != "" || a.CertFile != "" || a.KeyFile != "" || a.ClientCAFile != "" {
+			errs.add("admin.listen", "required when other admin settings are set")
+		}
+		return
+	}
+	validateListen(errs, "admin.listen", a.Listen)
+	if a.Listen == listen {
+		errs.add("admin.listen", "must differ from listen")
+	}
+	if a.Token != "" && len(a.Token) < minAdminTokenLength {
+		errs.add("admin.token", "must be at least %d characters", minAdminTokenLength)
+	}
+	if (a.CertFile == "") != (a.KeyFile == "") {
+		errs.add("admin.certFile", "admin.certFile and admin.keyFile must be set together")
+	}
+	if a.ClientCAFile != "" && a.CertFile == "" {
+		errs.add("admin.clientCAFile", "requires admin.certFile and admin.keyFile")
+	}
+	for path, file := range map[string]string{"admin.certFile": a.CertFile, "admin.keyFile": a.KeyFile, "admin.clientCAFile": a.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			errs.add(path, "%v", err)
+		}
+	}
+}
+
+func validateCORS(errs *ValidationErrors, cors *CORSConfig) {
+	for _, origin := range splitList(cors.AllowOrigin) {
+		if origin == "*" {
+			if cors.AllowCredentials {
+				errs.add("cors.allowOrigin", "\"*\" cannot be combined with allowCredentials")
+			}
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			errs.add("cors.allowOrigin", "invalid origin %q, expected scheme://host[:port] or *", origin)
+		}
+	}
+	for _, method := range splitList(cors.AllowMethods) {
+		switch strings.ToUpper(method) {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+			http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		default:
+			errs.add("cors.allowMethods", "unknown method %q", method)
+		}
+	}
+	for _, header := range splitList(cors.AllowHeaders) {
+		if header != "*" && strings.ContainsAny(header, " \t\"()<>@;:\\/[]?={}") {
+			errs.add("cors.allowHeaders", "invalid header name %q", header)
+		}
+	}
+}
+
+// splitList splits a comma-separated value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// runValidateCommand implements `immich-proxy validate`. It exits non-zero on
+// any problem so it can gate CI.
+func runValidateCommand(opts *globalOptions, stdout, stderr io.Writer) int {
+	cfg, err := opts.loadConfig()
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", opts.configPath, err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", opts.configPath, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s: OK\n", opts.configPath)
+	return 0
+}
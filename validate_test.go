@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// validConfig returns the smallest config Validate accepts, defaults applied.
+func validConfig() *Config {
+	c := &Config{}
+	c.Immich.URL = "http://immich:2283/"
+	c.Immich.APIKeys = []string{"key"}
+	c.applyDefaults()
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		paths  []string // YAML paths of the expected errors, in any order
+	}{
+		{name: "valid", modify: func(c *Config) {}},
+		{name: "missing url", modify: func(c *Config) { c.Immich.URL = "" }, paths: []string{"immich.url"}},
+		{name: "bad scheme", modify: func(c *Config) { c.Immich.URL = "ftp://immich" }, paths: []string{"immich.url"}},
+		{
+			name:   "no keys or logins",
+			modify: func(c *Config) { c.Immich.APIKeys = nil },
+			paths:  []string{"immich.api_keys"},
+		},
+		{
+			name: "logins instead of keys",
+			modify: func(c *Config) {
+				c.Immich.APIKeys = nil
+				c.Immich.Logins = []LoginConfig{{Email: "a@example.com", Password: "pw"}}
+			},
+		},
+		{
+			name: "duplicate and incomplete logins",
+			modify: func(c *Config) {
+				c.Immich.Logins = []LoginConfig{{Email: "a@example.com", Password: "pw"}, {Email: "A@example.com"}}
+			},
+			paths: []string{"immich.logins[1].email", "immich.logins[1].password"},
+		},
+		{
+			name:   "bad refresh interval",
+			modify: func(c *Config) { c.Immich.AlbumsRefreshInterval = "5" },
+			paths:  []string{"immich.albumsRefreshInterval"},
+		},
+		{
+			name:   "zero refresh interval",
+			modify: func(c *Config) { c.Immich.AlbumsRefreshInterval = "0s" },
+			paths:  []string{"immich.albumsRefreshInterval"},
+		},
+		{
+			name:   "bad sync scope",
+			modify: func(c *Config) { c.Immich.SyncScope = []string{"owned", "everything"} },
+			paths:  []string{"immich.syncScope[1]"},
+		},
+		{
+			name:   "negative cache ttl",
+			modify: func(c *Config) { c.Immich.MissCacheTTL = "-1s" },
+			paths:  []string{"immich.missCacheTTL"},
+		},
+		{
+			name:   "bad listen address",
+			modify: func(c *Config) { c.Listen = "8080" },
+			paths:  []string{"listen"},
+		},
+		{
+			name:   "bad log level and format",
+			modify: func(c *Config) { c.LogLevel, c.LogFormat = "loud", "xml" },
+			paths:  []string{"logLevel", "logFormat"},
+		},
+		{
+			name:   "bad trusted proxy",
+			modify: func(c *Config) { c.TrustedProxies = []string{"10.0.0.0/8", "10.0.0.300"} },
+			paths:  []string{"trustedProxies[1]"},
+		},
+		{
+			name:   "audit without path",
+			modify: func(c *Config) { c.Audit.Enabled = true },
+			paths:  []string{"audit.path"},
+		},
+		{
+			name: "webhooks without sync or secret",
+			modify: func(c *Config) {
+				c.Webhooks.URLs = []string{"https://hooks.example.com/x"}
+			},
+			paths: []string{"webhooks.secret", "webhooks.urls"},
+		},
+		{
+			name: "webhooks",
+			modify: func(c *Config) {
+				c.Immich.AlbumsSyncEnabled = true
+				c.Webhooks.URLs = []string{"https://hooks.example.com/x"}
+				c.Webhooks.Secret = "secret"
+			},
+		},
+		{
+			name:   "bad tracing exporter",
+			modify: func(c *Config) { c.Tracing.Exporter = "jaeger" },
+			paths:  []string{"tracing.exporter"},
+		},
+		{
+			name:   "sample ratio above 1",
+			modify: func(c *Config) { c.Tracing.SampleRatio = 1.5 },
+			paths:  []string{"tracing.sampleRatio"},
+		},
+		{
+			name:   "negative sample ratio",
+			modify: func(c *Config) { c.Tracing.SampleRatio = -0.1 },
+			paths:  []string{"tracing.sampleRatio"},
+		},
+		{
+			name: "every error at once",
+			modify: func(c *Config) {
+				c.Immich.URL = ""
+				c.LogLevel = "loud"
+				c.AlbumRules = []AlbumRule{{}}
+			},
+			paths: []string{"immich.url", "logLevel", "albumRules[0]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(c)
+			err := c.Validate()
+			if len(tt.paths) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			var errs ValidationErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("Validate() = %v, want ValidationErrors", err)
+			}
+			var got []string
+			for _, e := range errs {
+				got = append(got, e.Path)
+			}
+			slices.Sort(got)
+			want := slices.Clone(tt.paths)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("errors at %v, want %v\n%v", got, want, err)
+			}
+		})
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	c := validConfig()
+	if c.Immich.URL != "http://immich:2283" {
+		t.Errorf("immich.url = %q, want the trailing slash trimmed", c.Immich.URL)
+	}
+	if c.Listen != defaultListen || c.LogLevel != defaultLogLevel || c.Immich.AlbumsRefreshInterval != defaultAlbumsRefreshInterval {
+		t.Errorf("defaults not applied: listen %q, logLevel %q, albumsRefreshInterval %q",
+			c.Listen, c.LogLevel, c.Immich.AlbumsRefreshInterval)
+	}
+	if !slices.Equal(c.Immich.SyncScope, []string{scopeOwned}) {
+		t.Errorf("immich.syncScope = %v, want [owned]", c.Immich.SyncScope)
+	}
+	c.Listen = ":9000"
+	c.applyDefaults()
+	if c.Listen != ":9000" {
+		t.Errorf("applyDefaults replaced listen %q", c.Listen)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultWebhookMaxAttempts = 5
+	defaultWebhookTimeout     = 10 * time.Second
+	webhookQueueSize          = 100
+	webhookFirstBackoff       = 2 * time.Second
+
+	webhookEventAlbumUpdated = "album.updated"
+	webhookSignatureHeader   = "X-Immich-Proxy-Signature"
+)
+
+// WebhookConfig posts an event to each URL when a sync finds that the
+// assets of an album changed. Events are signed with an HMAC-SHA256 of the body keyed by Secret.
+type WebhookConfig struct {
+	URLs           []string `yaml:"urls,omitempty"`
+	Secret         string   `yaml:"secret,omitempty" secret:"true"`
+	Albums         []string `yaml:"albums,omitempty"` // album IDs or name globs, all albums if empty
+	MaxAttempts    int      `yaml:"maxAttempts,omitempty"`
+	Timeout        string   `yaml:"timeout,omitempty"`        // per attempt
+	DeadLetterFile string   `yaml:"deadLetterFile,omitempty"` // JSONL of events no attempt delivered
+}
+
+func (c WebhookConfig) Enabled() bool {
+	return len(c.URLs) > 0
+}
+
+// WebhookEvent is the JSON body posted to webhooks.
+type WebhookEvent struct {
+	ID                 string    `json:"id"`
+	Type               string    `json:"type"`
+	Time               time.Time `json:"time"`
+	AlbumID            string    `json:"albumId"`
+	AlbumName          string    `json:"albumName"`
+	AssetCount         int       `json:"assetCount"`
+	PreviousAssetCount int       `json:"previousAssetCount"`
+	AddedAssetIDs      []string  `json:"addedAssetIds"`
+}
+
+// deadLetter is a line of the dead-letter file. URLs often hold tokens, so
+// only the scheme and host are written, with the index into webhooks.urls.
+type deadLetter struct {
+	Time     time.Time    `json:"time"`
+	URL      string       `json:"url"`
+	URLIndex int          `json:"urlIndex"`
+	Attempts int          `json:"attempts"`
+	Error    string       `json:"error"`
+	Event    WebhookEvent `json:"event"`
+}
+
+// WebhookNotifier turns album updates found by the sync into webhook
+// events and delivers them in the background, retrying with backoff.
+type WebhookNotifier struct {
+	cfg         WebhookConfig
+	maxAttempts int
+	albums      *albumPolicy // the albums filter, as a rule for every key
+	httpClient  *http.Client
+	queue       chan AlbumUpdate
+
+	lock sync.Mutex // to protect writes to the dead-letter file
+}
+
+func NewWebhookNotifier(cfg WebhookConfig) (*WebhookNotifier, error) {
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhooks.timeout: %w", err)
+	}
+	n := &WebhookNotifier{
+		cfg:         cfg,
+		maxAttempts: cfg.MaxAttempts,
+		httpClient:  &http.Client{Timeout: timeout},
+		queue:       make(chan AlbumUpdate, webhookQueueSize),
+	}
+	if len(cfg.Albums) > 0 {
+		n.albums = newAlbumPolicy([]AlbumRule{{Allow: cfg.Albums}})
+	}
+	return n, nil
+}
+
+// AlbumsUpdated queues events for the updated albums the filter allows.
+// It never blocks; events that do not fit in the queue are dead-lettered.
+func (n *WebhookNotifier) AlbumsUpdated(updates []AlbumUpdate) {
+	for _, u := range updates {
+		if !n.albums.Allowed("", u.AlbumID, u.Name) {
+			continue
+		}
+		select {
+		case n.queue <- u:
+		default:
+			log.Warnf("Webhook queue is full, dropping event for album %s", u.AlbumID)
+			n.deadLetterAll(n.event(u, nil), 0, fmt.Errorf("queue full"))
+		}
+	}
+}
+
+// Start delivers queued events until ctx is done. Events still queued then
+// are dead-lettered.
+func (n *WebhookNotifier) Start(ctx context.Context) {
+	log.Infof("Webhooks enabled, posting album updates to %d URL(s)", len(n.cfg.URLs))
+	go func() {
+		for {
+			select {
+			case u := <-n.queue:
+				n.notify(ctx, u)
+			case <-ctx.Done():
+				for {
+					select {
+					case u := <-n.queue:
+						n.deadLetterAll(n.event(u, nil), 0, ctx.Err())
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+func (n *WebhookNotifier) event(u AlbumUpdate, added []string) WebhookEvent {
+	if added == nil {
+		added = []string{}
+	}
+	return WebhookEvent{
+		ID:                 newRequestID(),
+		Type:               webhookEventAlbumUpdated,
+		Time:               time.Now().UTC(),
+		AlbumID:            u.AlbumID,
+		AlbumName:          u.Name,
+		AssetCount:         u.After.Count,
+		PreviousAssetCount: u.Before.Count,
+		AddedAssetIDs:      added,
+	}
+}
+
+// notify builds the event for u and posts it to every URL concurrently.
+func (n *WebhookNotifier) notify(ctx context.Context, u AlbumUpdate) {
+	added := u.Added()
+	if added == nil {
+		// still worth a ping, just without the asset IDs
+		log.Debugf("Asset IDs of album %s before the change are unknown", u.AlbumID)
+	}
+	event := n.event(u, added)
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("Failed to encode webhook event: %v", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for i := range n.cfg.URLs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n.deliver(ctx, i, event, body)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// deliver posts body to the i-th URL, retrying with doubling backoff, and
+// dead-letters the event once every attempt failed.
+func (n *WebhookNotifier) deliver(ctx context.Context, i int, event WebhookEvent, body []byte) {
+	backoff := webhookFirstBackoff
+	target := n.describeURL(i)
+	var err error
+	for attempt := 1; attempt <= n.maxAttempts; attempt++ {
+		if err = n.post(ctx, n.cfg.URLs[i], event, body); err == nil {
+			webhookDeliveries.WithLabelValues("delivered").Inc()
+			log.Debugf("Webhook %s delivered to %s", event.ID, target)
+			return
+		}
+		log.Warnf("Webhook %s to %s failed (attempt %d/%d): %v", event.ID, target, attempt, n.maxAttempts, err)
+		if attempt == n.maxAttempts {
+			break
+		}
+		webhookDeliveries.WithLabelValues("retried").Inc()
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			n.deadLetter(i, event, attempt, ctx.Err())
+			return
+		}
+	}
+	n.deadLetter(i, event, n.maxAttempts, err)
+}
+
+// post sends body to target once. Errors never contain target in full.
+func (n *WebhookNotifier) post(ctx context.Context, target string, event WebhookEvent, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: invalid URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Immich-Proxy-Event", event.Type)
+	req.Header.Set("X-Immich-Proxy-Delivery", event.ID)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhook(n.cfg.Secret, body))
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactURL(target)
+		}
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Warnf("failed to close response body: %v", err)
+		}
+	}()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// signWebhook returns the hex HMAC-SHA256 of body keyed by secret.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *WebhookNotifier) deadLetterAll(event WebhookEvent, attempts int, err error) {
+	for i := range n.cfg.URLs {
+		n.deadLetter(i, event, attempts, err)
+	}
+}
+
+// deadLetter appends the event undelivered to the i-th URL to the
+// dead-letter file, or only logs it when there is none.
+func (n *WebhookNotifier) deadLetter(i int, event WebhookEvent, attempts int, err error) {
+	webhookDeliveries.WithLabelValues("dead_lettered").Inc()
+	log.Errorf("Giving up on webhook %s to %s for album %s after %d attempt(s): %v",
+		event.ID, n.describeURL(i), event.AlbumID, attempts, err)
+	if n.cfg.DeadLetterFile == "" {
+		return
+	}
+	b, encErr := json.Marshal(deadLetter{
+		Time: time.Now().UTC(), URL: redactURL(n.cfg.URLs[i]), URLIndex: i,
+		Attempts: attempts, Error: err.Error(), Event: event,
+	})
+	if encErr != nil {
+		log.Errorf("Failed to encode dead letter: %v", encErr)
+		return
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	f, openErr := os.OpenFile(n.cfg.DeadLetterFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if openErr != nil {
+		log.Errorf("Failed to open webhook dead-letter file: %v", openErr)
+		return
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		log.Errorf("Failed to write webhook dead letter: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Warnf("failed to close webhook dead-letter file: %v", err)
+	}
+}
+
+// describeURL names the i-th URL in logs without its path and query.
+func (n *WebhookNotifier) describeURL(i int) string {
+	return fmt.Sprintf("webhooks.urls[%d] (%s)", i, redactURL(n.cfg.URLs[i]))
+}
+
+// redactURL returns the scheme and host of rawURL only, as paths and
+// queries of webhook URLs often hold tokens.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "[invalid URL]"
+	}
+	return u.Scheme + "://" + u.Host
+}